@@ -0,0 +1,203 @@
+package cpd
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// ///////////////////// CONSTANTS
+const BOOT_SHUFFLE    = 0 // permute the samples, assumes they are exchangeable
+const BOOT_BLOCK      = 1 // circular moving blocks of fixed length
+const BOOT_STATIONARY = 2 // stationary bootstrap, block lengths geometric around the mean
+
+const BLOCK_LAGS = 5 // insignificant autocorrelations in a row that end the dependence
+
+
+func (d *Detector) SetBlockBootstrap(mode, blockLen int){
+
+	//-----------------------------------------------------------------------------------
+	//  Resamples blocks of consecutive samples instead of single samples, so the
+	//  bootstrap keeps the short range dependence of autocorrelated series
+	//	Input:   BOOT_SHUFFLE, BOOT_BLOCK or BOOT_STATIONARY, (mean) block length, 0
+	//		 to choose it from the data of each segment
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.BootMode=mode
+	d.opts.BlockLen=blockLen
+}
+
+func (opts Options) validateBlock()(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Checks the block bootstrap options
+	//	Input:
+	//	Output:  error wrapping ErrBadOption
+	//-----------------------------------------------------------------------------------
+
+	if (opts.BootMode < BOOT_SHUFFLE) || (opts.BootMode > BOOT_STATIONARY){
+		return fmt.Errorf("%w: bootstrap mode %d", ErrBadOption, opts.BootMode)
+	}
+	if (opts.BlockLen < 0){
+		return fmt.Errorf("%w: block length %d", ErrBadOption, opts.BlockLen)
+	}
+
+	return nil
+}
+
+func autocov(data []float64, avg float64, lag int)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Autocovariance at a lag, pairs with a NaN (missing) value are skipped
+	//	Input:   data, average, lag
+	//	Output:  autocovariance, divided by the data length
+	//-----------------------------------------------------------------------------------
+
+	var sum float64
+
+	for i := lag; i < len(data); i++ {
+		prod:=(data[i]-avg)*(data[i-lag]-avg)
+		if (!math.IsNaN(prod)){
+			sum=sum+prod
+		}
+	}
+
+	return sum/float64(len(data))
+}
+
+func autoBlockLen(data []float64, mode int)(int){
+
+	//-----------------------------------------------------------------------------------
+	//  Block length from the autocorrelation of the data (Politis and White 2004,
+	//  with the correction of Patton, Politis and White 2009)
+	//	Input:   data, BOOT_BLOCK or BOOT_STATIONARY
+	//	Output:  block length, 1 for uncorrelated data
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var m int
+	var g,big float64
+
+	n:=len(data)
+	if (n < 2*BLOCK_LAGS){
+		return 1
+	}
+	avg:=calcAvg(data)
+	r0:=autocov(data,avg,0)
+	if (r0 <= 0) || (math.IsNaN(r0)){
+		return 1
+	}
+
+	//lags to look at, and the bound below which a correlation is noise
+	maxLag:=int(math.Ceil(math.Sqrt(float64(n))))+BLOCK_LAGS
+	if (maxLag > n-1){
+		maxLag=n-1
+	}
+	bound:=2*math.Sqrt(math.Log10(float64(n))/float64(n))
+
+	//first lag followed by BLOCK_LAGS insignificant correlations
+	acf:=make([]float64,maxLag+1)
+	for k := 1; k <= maxLag; k++ {
+		acf[k]=autocov(data,avg,k)/r0
+	}
+	m=maxLag-BLOCK_LAGS
+	for k := 1; k+BLOCK_LAGS-1 <= maxLag; k++ {
+		quiet:=true
+		for j := k; j < k+BLOCK_LAGS; j++ {
+			if (math.Abs(acf[j]) >= bound){
+				quiet=false
+				break
+			}
+		}
+		if (quiet){
+			m=k-1
+			break
+		}
+	}
+	if (m < 1){
+		return 1
+	}
+
+	//flat-top lag window over 2m lags
+	lagWin:=2*m
+	if (lagWin > maxLag){
+		lagWin=maxLag
+	}
+	g=r0
+	for k := 1; k <= lagWin; k++ {
+		t:=float64(k)/float64(lagWin)
+		weight:=1.0
+		if (t > 0.5){
+			weight=2*(1-t)
+		}
+		cov:=acf[k]*r0
+		g=g+2*weight*cov
+		big=big+2*weight*float64(k)*cov
+	}
+
+	dConst:=2*g*g
+	if (mode == BOOT_BLOCK){
+		dConst=4.0/3.0*g*g
+	}
+	if (dConst <= 0){
+		return 1
+	}
+
+	block:=int(math.Ceil(math.Cbrt(2*big*big/dConst)*math.Cbrt(float64(n))))
+	if (block < 1){
+		block=1
+	}
+	if (block > n/2){
+		block=n/2
+	}
+
+	return block
+}
+
+func (d *Detector) blockLen(data []float64)(int){
+
+	//-----------------------------------------------------------------------------------
+	//  Block length used to bootstrap a segment
+	//	Input:   segment data, as resampled
+	//	Output:  block length, 1 for plain shuffles
+	//-----------------------------------------------------------------------------------
+
+	if (d.opts.BootMode == BOOT_SHUFFLE){
+		return 1
+	}
+	if (d.opts.BlockLen > 0){
+		return d.opts.BlockLen
+	}
+
+	return autoBlockLen(data,d.opts.BootMode)
+}
+
+func resampleBlocks(rng *rand.Rand, dst, src []float64, mode, block int){
+
+	//-----------------------------------------------------------------------------------
+	//  Fills dst with blocks of src drawn with replacement, wrapping around the end
+	//	Input:   random source, destination, data, BOOT_BLOCK or BOOT_STATIONARY,
+	//		 (mean) block length
+	//	Output:  dst filled
+	//-----------------------------------------------------------------------------------
+
+	n:=len(src)
+	pos:=0
+	jump:=1/float64(block)
+
+	for i := range dst{
+		switch {
+		case (mode == BOOT_BLOCK) && (i % block == 0):
+			pos=rng.Intn(n)
+		case (mode == BOOT_STATIONARY) && ((i == 0) || (rng.Float64() < jump)):
+			pos=rng.Intn(n)
+		default:
+			pos=pos+1
+			if (pos == n){
+				pos=0
+			}
+		}
+		dst[i]=src[pos]
+	}
+}
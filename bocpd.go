@@ -0,0 +1,327 @@
+package cpd
+
+import (
+	"fmt"
+	"math"
+)
+
+// ///////////////////// CONSTANTS
+const MODEL_GAUSSIAN = 0 // normal data, unknown mean and variance (Normal-Gamma prior)
+const MODEL_POISSON  = 1 // count data, unknown rate (Gamma prior)
+
+const DEF_RUN_LENGTH = 250  // expected samples between changes, 1/hazard
+const DEF_RUN_TRIM   = 1e-9 // run lengths less likely than this are dropped from the tail
+const DEF_CHG_LAG    = 5    // samples a change may lie back and still count as recent
+
+
+// ///////////////////// TYPES
+
+// BayesOptions configures an online detector.  Zero values select the defaults.
+type BayesOptions struct {
+	Model     int
+	RunLength float64 // expected samples between changes, the hazard is 1/RunLength
+	MaxRun    int     // longest run length tracked, 0 for no limit
+	Trim      float64 // tail probability dropped, negative to keep every run length
+	Lag       int     // run lengths up to Lag count towards ChgProb
+
+	// prior of every new segment.  MODEL_GAUSSIAN uses all four, MODEL_POISSON
+	// uses Alpha and Beta (shape and rate of the rate).  Kappa, Alpha and Beta
+	// default to 1
+	Mu    float64
+	Kappa float64
+	Alpha float64
+	Beta  float64
+}
+
+// BayesT is the state of an online detector after a sample.
+type BayesT struct {
+	Index    int64     // sample index, 0-based
+	RunProbs []float64 // posterior of the run length, RunProbs[r] = P(run length r)
+	ChgProb  float64   // probability of a change within the last Lag samples
+	MAPRun   int64     // most likely run length
+	ChgStart int64     // first sample of the current segment, Index-MAPRun+1
+	Mean     float64   // predicted mean of the next sample
+}
+
+// Bayes is an online change point detector (Adams and MacKay 2007): it keeps the
+// posterior distribution of the run length, the samples since the last change,
+// and updates it one sample at a time.  Not safe for concurrent use.
+type Bayes struct {
+	opts   BayesOptions
+	hazard float64
+	index  int64
+	probs  []float64 // run length posterior
+	mu     []float64 // posterior parameters per run length
+	kappa  []float64
+	alpha  []float64
+	beta   []float64
+	logP   []float64 // scratch, log predictive per run length
+}
+
+
+func (opts BayesOptions) withDefaults()(BayesOptions){
+
+	//-----------------------------------------------------------------------------------
+	//  Fills the zero options with the defaults
+	//	Input:   options
+	//	Output:  options to use
+	//-----------------------------------------------------------------------------------
+
+	if (opts.RunLength == 0){
+		opts.RunLength=DEF_RUN_LENGTH
+	}
+	if (opts.Trim == 0){
+		opts.Trim=DEF_RUN_TRIM
+	}
+	if (opts.Lag == 0){
+		opts.Lag=DEF_CHG_LAG
+	}
+	if (opts.Kappa == 0){
+		opts.Kappa=1
+	}
+	if (opts.Alpha == 0){
+		opts.Alpha=1
+	}
+	if (opts.Beta == 0){
+		opts.Beta=1
+	}
+
+	return opts
+}
+
+func (opts BayesOptions) validate()(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Checks the options, after the defaults are filled in
+	//	Input:   options
+	//	Output:  error wrapping ErrBadOption, nil if the options are usable
+	//-----------------------------------------------------------------------------------
+
+	if (opts.Model != MODEL_GAUSSIAN) && (opts.Model != MODEL_POISSON){
+		return fmt.Errorf("%w: model %d", ErrBadOption, opts.Model)
+	}
+	if (opts.RunLength < 1) || (math.IsInf(opts.RunLength,0)){
+		return fmt.Errorf("%w: run length %v", ErrBadOption, opts.RunLength)
+	}
+	if (opts.MaxRun < 0){
+		return fmt.Errorf("%w: max run %d", ErrBadOption, opts.MaxRun)
+	}
+	if (opts.Lag < 0){
+		return fmt.Errorf("%w: lag %d", ErrBadOption, opts.Lag)
+	}
+	if (opts.Kappa <= 0) || (opts.Alpha <= 0) || (opts.Beta <= 0){
+		return fmt.Errorf("%w: prior %v %v %v", ErrBadOption, opts.Kappa, opts.Alpha, opts.Beta)
+	}
+
+	return nil
+}
+
+func NewBayes(opts BayesOptions)(*Bayes, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Creates an online detector
+	//	Input:   options, zero values for the defaults
+	//	Output:  detector, error if the options are out of range
+	//-----------------------------------------------------------------------------------
+
+	opts=opts.withDefaults()
+	if err:=opts.validate(); err != nil{
+		return nil, err
+	}
+
+	b:=&Bayes{opts: opts, hazard: 1/opts.RunLength}
+	b.Reset()
+
+	return b, nil
+}
+
+func (b *Bayes) Reset(){
+
+	//-----------------------------------------------------------------------------------
+	//  Forgets all samples: the next one starts a new segment
+	//	Input:
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	b.index=0
+	b.probs=append(b.probs[:0],1)
+	b.mu=append(b.mu[:0],b.opts.Mu)
+	b.kappa=append(b.kappa[:0],b.opts.Kappa)
+	b.alpha=append(b.alpha[:0],b.opts.Alpha)
+	b.beta=append(b.beta[:0],b.opts.Beta)
+}
+
+func (b *Bayes) logPredictive(r int, x float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Log density of x given the samples of run length r: Student-t for
+	//  MODEL_GAUSSIAN, negative binomial for MODEL_POISSON
+	//	Input:   run length, sample
+	//	Output:  log density
+	//-----------------------------------------------------------------------------------
+
+	alpha:=b.alpha[r]
+	beta:=b.beta[r]
+
+	if (b.opts.Model == MODEL_POISSON){
+		lgX1, _ := math.Lgamma(x+1)
+		lgXA, _ := math.Lgamma(x+alpha)
+		lgA, _ := math.Lgamma(alpha)
+		return lgXA-lgA-lgX1+alpha*math.Log(beta/(beta+1))-x*math.Log(beta+1)
+	}
+
+	kappa:=b.kappa[r]
+	df:=2*alpha
+	scale2:=beta*(kappa+1)/(alpha*kappa)
+	z:=(x-b.mu[r])*(x-b.mu[r])/(df*scale2)
+	lgHalf, _ := math.Lgamma((df+1)/2)
+	lgDf, _ := math.Lgamma(df/2)
+
+	return lgHalf-lgDf-0.5*math.Log(df*math.Pi*scale2)-(df+1)/2*math.Log1p(z)
+}
+
+func (b *Bayes) Update(x float64)(BayesT, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Adds a sample and returns the new run length posterior.  A NaN (missing)
+	//  sample ages the runs without evidence
+	//	Input:   sample
+	//	Output:  state after the sample, error if the sample is infinite or, for
+	//		 MODEL_POISSON, negative
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var state BayesT
+	var maxLog,total,chgMass float64
+
+	if (math.IsInf(x,0)){
+		return state, ErrBadValue
+	}
+	if (b.opts.Model == MODEL_POISSON) && (x < 0){
+		return state, ErrNegativeCount
+	}
+	missing:=math.IsNaN(x)
+	n:=len(b.probs)
+
+	//predictive of x under each run length
+	b.logP=b.logP[:0]
+	maxLog=math.Inf(-1)
+	for r := 0; r < n; r++ {
+		logP:=0.0
+		if (!missing){
+			logP=b.logPredictive(r,x)
+		}
+		b.logP=append(b.logP,logP)
+		if (b.probs[r] > 0) && (logP > maxLog){
+			maxLog=logP
+		}
+	}
+
+	//growth and change probabilities, scaled by the best predictive against underflow
+	b.probs=append(b.probs,0)
+	for r := n-1; r >= 0; r-- {
+		weight:=b.probs[r]*math.Exp(b.logP[r]-maxLog)
+		b.probs[r+1]=weight*(1-b.hazard)
+		chgMass=chgMass+weight*b.hazard
+	}
+	b.probs[0]=chgMass
+	for _, p := range b.probs{
+		total=total+p
+	}
+	for r := range b.probs{
+		b.probs[r]=b.probs[r]/total
+	}
+
+	//posterior parameters, run length r+1 extends run length r with x
+	b.mu=append(b.mu,0)
+	b.kappa=append(b.kappa,0)
+	b.alpha=append(b.alpha,0)
+	b.beta=append(b.beta,0)
+	for r := n-1; r >= 0; r-- {
+		b.mu[r+1], b.kappa[r+1], b.alpha[r+1], b.beta[r+1] = b.mu[r], b.kappa[r], b.alpha[r], b.beta[r]
+		if (missing){
+			continue
+		}
+		if (b.opts.Model == MODEL_POISSON){
+			b.alpha[r+1]=b.alpha[r]+x
+			b.beta[r+1]=b.beta[r]+1
+			continue
+		}
+		b.mu[r+1]=(b.kappa[r]*b.mu[r]+x)/(b.kappa[r]+1)
+		b.kappa[r+1]=b.kappa[r]+1
+		b.alpha[r+1]=b.alpha[r]+0.5
+		b.beta[r+1]=b.beta[r]+b.kappa[r]*(x-b.mu[r])*(x-b.mu[r])/(2*(b.kappa[r]+1))
+	}
+	b.mu[0], b.kappa[0], b.alpha[0], b.beta[0] = b.opts.Mu, b.opts.Kappa, b.opts.Alpha, b.opts.Beta
+
+	b.truncate()
+
+	//report
+	state.Index=b.index
+	state.RunProbs=make([]float64,len(b.probs))
+	copy(state.RunProbs,b.probs)
+	for r, p := range b.probs{
+		if (r <= b.opts.Lag){
+			state.ChgProb=state.ChgProb+p
+		}
+		if (p > b.probs[state.MAPRun]){
+			state.MAPRun=int64(r)
+		}
+		if (b.opts.Model == MODEL_POISSON){
+			state.Mean=state.Mean+p*b.alpha[r]/b.beta[r]
+		}else{
+			state.Mean=state.Mean+p*b.mu[r]
+		}
+	}
+	state.ChgStart=b.index-state.MAPRun+1
+	b.index=b.index+1
+
+	return state, nil
+}
+
+func (b *Bayes) truncate(){
+
+	//-----------------------------------------------------------------------------------
+	//  Drops unlikely long run lengths, and folds runs beyond MaxRun into the last one
+	//	Input:
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	var total float64
+
+	n:=len(b.probs)
+
+	if (b.opts.MaxRun > 0) && (n > b.opts.MaxRun+1){
+		for r := b.opts.MaxRun+1; r < n; r++ {
+			b.probs[b.opts.MaxRun]=b.probs[b.opts.MaxRun]+b.probs[r]
+		}
+		n=b.opts.MaxRun+1
+	}
+
+	for (n > 1) && (b.probs[n-1] < b.opts.Trim){
+		n=n-1
+	}
+	for r := 0; r < n; r++ {
+		total=total+b.probs[r]
+	}
+	for r := 0; r < n; r++ {
+		b.probs[r]=b.probs[r]/total
+	}
+
+	b.probs=b.probs[:n]
+	b.mu=b.mu[:n]
+	b.kappa=b.kappa[:n]
+	b.alpha=b.alpha[:n]
+	b.beta=b.beta[:n]
+}
+
+func (b *Bayes) Index()(int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the number of samples seen since the last reset
+	//	Input:
+	//	Output:  sample count
+	//-----------------------------------------------------------------------------------
+
+	return b.index
+}
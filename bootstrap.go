@@ -0,0 +1,341 @@
+package cpd
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// ///////////////////// CONSTANTS
+const BOOT_CHUNK = 256 // bootstrap iterations handed to a worker at a time
+const STOP_Z     = 3.29 // early stopping interval, 99.9% two sided
+
+
+// ///////////////////// GLOBALS
+
+// shuffle buffers and random sources, reused across chunks and analyses
+var G_bootBufs sync.Pool
+
+
+// ///////////////////// TYPES
+
+// bootBuf is the scratch space of one bootstrap chunk.
+type bootBuf struct {
+	data []float64
+	rng  *rand.Rand
+}
+
+// bootPool runs bootstrap chunks on a fixed number of goroutines.  Every chunk
+// seeds its own random source from the analysis seed, the segment and the
+// chunk number, so results do not depend on the number of workers.
+type bootPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+	size int
+}
+
+
+func newBootPool(workers int)(*bootPool){
+
+	//-----------------------------------------------------------------------------------
+	//  Starts the bootstrap workers
+	//	Input:   number of workers
+	//	Output:  running pool, stop it with close
+	//-----------------------------------------------------------------------------------
+
+	pool:=&bootPool{jobs: make(chan func()), size: workers}
+
+	for w := 0; w < workers; w++ {
+		pool.wg.Add(1)
+		go func(){
+			defer pool.wg.Done()
+			for job := range pool.jobs{
+				job()
+			}
+		}()
+	}
+
+	return pool
+}
+
+func (pool *bootPool) close(){
+
+	//-----------------------------------------------------------------------------------
+	//  Stops the workers once all submitted chunks are done
+	//	Input:
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	close(pool.jobs)
+	pool.wg.Wait()
+}
+
+func (d *Detector) SetWorkers(workers int){
+
+	//-----------------------------------------------------------------------------------
+	//  Number of goroutines used for the bootstrap, 0 for GOMAXPROCS
+	//	Input:   worker count
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.Workers=workers
+}
+
+func (d *Detector) SetSeed(seed int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Fixes the seed of all random draws so results can be reproduced.  0 draws a
+	//  new seed for every analysis
+	//	Input:   seed
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.Seed=seed
+}
+
+func (d *Detector) SetRandSource(src rand.Source){
+
+	//-----------------------------------------------------------------------------------
+	//  Draws the seed of each analysis from src, unless a seed was set
+	//	Input:   random source, nil for the math/rand default
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.Source=src
+}
+
+func (d *Detector) GetSeed()(int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the seed used by the last analysis
+	//	Input:
+	//	Output:  seed
+	//-----------------------------------------------------------------------------------
+
+	return d.seed
+}
+
+func (d *Detector) SetEarlyStop(earlyStop bool){
+
+	//-----------------------------------------------------------------------------------
+	//  Stops the bootstrap of a segment as soon as the confidence is clearly above
+	//  or below the minimum, instead of always running the full count
+	//	Input:   true for early stopping
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.EarlyStop=earlyStop
+}
+
+func wilson(gtCount, n int64, z float64)(float64, float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Wilson score interval of a proportion
+	//	Input:   successes, trials, normal quantile
+	//	Output:  low and high bound, both within 0..1
+	//-----------------------------------------------------------------------------------
+
+	p:=float64(gtCount)/float64(n)
+	z2:=z*z
+	denom:=1+z2/float64(n)
+	center:=(p+z2/(2*float64(n)))/denom
+	half:=z*math.Sqrt(p*(1-p)/float64(n)+z2/(4*float64(n)*float64(n)))/denom
+
+	return center-half, center+half
+}
+
+func workerCount(workers int)(int){
+
+	//-----------------------------------------------------------------------------------
+	//  Resolves the configured worker count
+	//	Input:   configured count, 0 for GOMAXPROCS
+	//	Output:  workers to start
+	//-----------------------------------------------------------------------------------
+
+	if (workers <= 0){
+		return runtime.GOMAXPROCS(0)
+	}
+
+	return workers
+}
+
+func mixSeed(seed int64, vals ...int64)(int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Derives a new seed from a seed and some values (splitmix64 finalizer)
+	//	Input:   seed, values to mix in
+	//	Output:  derived seed
+	//-----------------------------------------------------------------------------------
+
+	z:=uint64(seed)
+	for _, val := range vals{
+		z=z^uint64(val)
+		z=z+0x9e3779b97f4a7c15
+		z=(z^(z>>30))*0xbf58476d1ce4e5b9
+		z=(z^(z>>27))*0x94d049bb133111eb
+		z=z^(z>>31)
+	}
+
+	return int64(z)
+}
+
+func bootChunk(seed int64, slice []float64, stat func([]float64) float64, origDelta float64, count int64, mode, block int)(int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Runs part of the bootstrap: shuffles the data count times and counts how often
+	//  the original statistic (cusum range) beats the shuffled one.  Block modes
+	//  resample blocks of the data instead of shuffling it
+	//	Input:   chunk seed, segment data, statistic, original statistic,
+	//		 number of shuffles, BOOT_SHUFFLE/BLOCK/STATIONARY, block length
+	//	Output:  number of shuffles beaten
+	//-----------------------------------------------------------------------------------
+
+	var newDelta float64
+	var gtCount int64
+	var bootstrap []float64
+
+	//reuse a buffer and random source
+	buf, _ := G_bootBufs.Get().(*bootBuf)
+	if (buf == nil){
+		buf=&bootBuf{rng: rand.New(rand.NewSource(seed))}
+	}else{
+		buf.rng.Seed(seed)
+	}
+	defer G_bootBufs.Put(buf)
+	rng:=buf.rng
+
+	//get a copy of the data
+	if (cap(buf.data) < len(slice)){
+		buf.data=make([]float64,len(slice))
+	}
+	bootstrap=buf.data[:len(slice)]
+	copy(bootstrap,slice)
+
+	for bootIndex := int64(0); bootIndex < count; bootIndex++ {
+		//random sort the data in slice
+		if (mode == BOOT_SHUFFLE){
+			for i := range bootstrap{
+				j:=rng.Intn(i + 1)
+				bootstrap[i], bootstrap[j] = bootstrap[j], bootstrap[i]
+			}
+		}else{
+			resampleBlocks(rng,bootstrap,slice,mode,block)
+		}
+		//get cusum of random ordered data
+		newDelta=stat(bootstrap)
+
+		if (origDelta > newDelta){
+			gtCount=gtCount+1
+		}
+	}
+
+	return gtCount
+}
+
+func (d *Detector) bootstrapConf(ctx context.Context, slice []float64, stat func([]float64) float64, origDelta float64, base_start, base_end, test int64)(float64, int64, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Confidence that a segment holds a change: the share of shuffles of the data
+	//  whose cusum range is below the original one.  Chunks run on the worker pool
+	//  when there is one, and none starts once the context is done.  With early
+	//  stopping the chunks are judged in order and the bootstrap ends once the
+	//  interval around the confidence no longer contains the minimum; the outcome
+	//  is the same for any number of workers
+	//	Input:   context, segment data, statistic of a shuffle, original statistic,
+	//		 segment bounds, test number so tests of one segment draw different
+	//		 shuffles
+	//	Output:  confidence in percent, shuffles used, error if cancelled
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var gtCount,iters int64
+	var window int64
+	var wg sync.WaitGroup
+
+	block:=d.blockLen(slice)
+	segSeed:=mixSeed(d.seed,base_start,base_end)
+	if (test != 0){
+		segSeed=mixSeed(segSeed,test)
+	}
+	chunks:=(d.opts.Bootstrap+BOOT_CHUNK-1)/BOOT_CHUNK
+	results:=make([]int64,chunks)
+	threshold:=d.opts.MinConf/100
+
+	//chunks run together before being judged
+	window=chunks
+	if (d.opts.EarlyStop){
+		window=1
+		if (d.pool != nil){
+			window=int64(d.pool.size)
+		}
+	}
+
+	for start := int64(0); start < chunks; start=start+window {
+		end:=start+window
+		if (end > chunks){
+			end=chunks
+		}
+
+		for chunk := start; chunk < end; chunk++ {
+			//check for cancellation every chunk
+			if (ctx.Err() != nil){
+				break
+			}
+			chunk:=chunk
+			seed:=mixSeed(segSeed,chunk)
+			count:=chunkCount(d.opts.Bootstrap,chunk)
+
+			if (d.pool == nil){
+				results[chunk]=bootChunk(seed,slice,stat,origDelta,count,d.opts.BootMode,block)
+				continue
+			}
+
+			wg.Add(1)
+			d.pool.jobs <- func(){
+				defer wg.Done()
+				if (ctx.Err() != nil){
+					return
+				}
+				results[chunk]=bootChunk(seed,slice,stat,origDelta,count,d.opts.BootMode,block)
+			}
+		}
+		wg.Wait()
+		if err:=ctx.Err(); err != nil{
+			return 0, iters, err
+		}
+
+		//judge the chunks in order
+		for chunk := start; chunk < end; chunk++ {
+			gtCount=gtCount+results[chunk]
+			iters=iters+chunkCount(d.opts.Bootstrap,chunk)
+
+			if (d.opts.EarlyStop) && (iters < d.opts.Bootstrap){
+				lo, hi := wilson(gtCount,iters,STOP_Z)
+				if (lo >= threshold) || (hi < threshold){
+					return float64(100*(float64(gtCount)/float64(iters))), iters, nil
+				}
+			}
+		}
+	}
+
+	//calculate change confidence:
+	return float64(100*(float64(gtCount)/float64(iters))), iters, nil
+}
+
+func chunkCount(bootstrap, chunk int64)(int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Number of shuffles in a chunk, the last one may be short
+	//	Input:   bootstrap count, chunk number
+	//	Output:  shuffles
+	//-----------------------------------------------------------------------------------
+
+	count:=bootstrap-chunk*BOOT_CHUNK
+	if (count > BOOT_CHUNK){
+		count=BOOT_CHUNK
+	}
+
+	return count
+}
@@ -0,0 +1,271 @@
+package cpd
+
+import (
+	"fmt"
+	"math"
+)
+
+// ///////////////////// CONSTANTS
+const DEF_CHART_K     = 0.5 // slack, in standard deviations: detects shifts of 2k
+const DEF_CHART_H     = 5   // decision interval, in standard deviations
+const DEF_CALIBRATION = 30  // samples used to estimate the target and sigma
+
+
+// ///////////////////// TYPES
+
+// ChartOptions configures a CUSUM chart.  With Sigma 0 the target and standard
+// deviation are estimated from the first Calibrate samples, otherwise Target and
+// Sigma are used as given.
+type ChartOptions struct {
+	Target      float64
+	Sigma       float64
+	K           float64 // slack in standard deviations, 0 for DEF_CHART_K
+	H           float64 // decision interval in standard deviations, 0 for DEF_CHART_H
+	Calibrate   int     // calibration samples, 0 for DEF_CALIBRATION when Sigma is 0
+	Recalibrate bool    // estimate the target and sigma again after every signal,
+	                    // needs a Calibrate window
+}
+
+// ChartT is the state of a CUSUM chart after a sample.
+type ChartT struct {
+	Index       int64   // sample index, 0-based
+	Value       float64
+	CPlus       float64 // upper cumulative sum, signals above H*Sigma
+	CMinus      float64 // lower cumulative sum
+	Signal      bool
+	Direction   int     // +1 up, -1 down, 0 no signal
+	ChgStart    int64   // estimated first sample of the shift, -1 without a signal
+	Calibrating bool    // the sample went into the calibration window
+}
+
+// Chart is a two sided tabular CUSUM control chart (Page 1954) that takes one
+// sample at a time.  After a signal both sums restart at 0.  Not safe for
+// concurrent use.
+type Chart struct {
+	opts   ChartOptions
+	target float64
+	sigma  float64
+	index  int64
+	cPlus  float64
+	cMinus float64
+	plusStart  int64 // last sample the sums were at 0
+	minusStart int64
+	calib  []float64 // calibration window, nil once calibrated
+}
+
+
+func (opts ChartOptions) withDefaults()(ChartOptions){
+
+	//-----------------------------------------------------------------------------------
+	//  Fills the zero options with the defaults
+	//	Input:   options
+	//	Output:  options to use
+	//-----------------------------------------------------------------------------------
+
+	if (opts.K == 0){
+		opts.K=DEF_CHART_K
+	}
+	if (opts.H == 0){
+		opts.H=DEF_CHART_H
+	}
+	if (opts.Sigma == 0) && (opts.Calibrate == 0){
+		opts.Calibrate=DEF_CALIBRATION
+	}
+
+	return opts
+}
+
+func (opts ChartOptions) validate()(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Checks the options, after the defaults are filled in
+	//	Input:   options
+	//	Output:  error wrapping ErrBadOption, nil if the options are usable
+	//-----------------------------------------------------------------------------------
+
+	if (opts.K < 0) || (opts.H <= 0){
+		return fmt.Errorf("%w: slack %v, decision interval %v", ErrBadOption, opts.K, opts.H)
+	}
+	if (opts.Sigma < 0) || (math.IsInf(opts.Sigma,0)) || (math.IsInf(opts.Target,0)){
+		return fmt.Errorf("%w: target %v, sigma %v", ErrBadOption, opts.Target, opts.Sigma)
+	}
+	if (opts.Calibrate < 0) || (((opts.Sigma == 0) || (opts.Recalibrate)) && (opts.Calibrate < 2)){
+		return fmt.Errorf("%w: calibration window %d", ErrBadOption, opts.Calibrate)
+	}
+
+	return nil
+}
+
+func NewChart(opts ChartOptions)(*Chart, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Creates a CUSUM chart
+	//	Input:   options, zero values for the defaults
+	//	Output:  chart, error if the options are out of range
+	//-----------------------------------------------------------------------------------
+
+	opts=opts.withDefaults()
+	if err:=opts.validate(); err != nil{
+		return nil, err
+	}
+
+	c:=&Chart{opts: opts}
+	c.Reset()
+
+	return c, nil
+}
+
+func (c *Chart) Reset(){
+
+	//-----------------------------------------------------------------------------------
+	//  Clears the sums and, when calibrating, the target and sigma
+	//	Input:
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	c.index=0
+	c.restart()
+	c.target=c.opts.Target
+	c.sigma=c.opts.Sigma
+	c.calib=nil
+	if (c.opts.Sigma == 0){
+		c.calib=make([]float64,0,c.opts.Calibrate)
+	}
+}
+
+func (c *Chart) restart(){
+
+	//-----------------------------------------------------------------------------------
+	//  Sets both sums back to 0 from the next sample on
+	//	Input:
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	c.cPlus=0
+	c.cMinus=0
+	c.plusStart=c.index
+	c.minusStart=c.index
+}
+
+func (c *Chart) calibrate(x float64)(bool){
+
+	//-----------------------------------------------------------------------------------
+	//  Adds a sample to the calibration window, and sets the target and sigma once
+	//  the window is full
+	//	Input:   sample
+	//	Output:  true if the sample was used for calibration
+	//-----------------------------------------------------------------------------------
+
+	if (c.calib == nil){
+		return false
+	}
+
+	c.calib=append(c.calib,x)
+	if (len(c.calib) < c.opts.Calibrate){
+		return true
+	}
+
+	c.target=calcAvg(c.calib)
+	c.sigma=calcStdev(c.calib,c.target)
+	if (c.sigma <= 0) || (math.IsNaN(c.sigma)){
+		//flat window, any move away from it is a shift
+		c.sigma=1e-12*math.Max(1,math.Abs(c.target))
+	}
+	if (math.IsNaN(c.target)){
+		//window of missing values only, keep calibrating
+		c.calib=c.calib[:0]
+		return true
+	}
+	c.calib=nil
+	c.restart()
+
+	return true
+}
+
+func (c *Chart) Add(x float64)(ChartT, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Adds a sample to the chart.  NaN (missing) samples leave the sums unchanged
+	//	Input:   sample
+	//	Output:  state after the sample, error if the sample is infinite
+	//-----------------------------------------------------------------------------------
+
+	var state ChartT
+
+	if (math.IsInf(x,0)){
+		return state, ErrBadValue
+	}
+
+	state.Index=c.index
+	state.Value=x
+	state.ChgStart=-1
+	c.index=c.index+1
+
+	if (c.calibrate(x)){
+		state.Calibrating=true
+		return state, nil
+	}
+
+	if (!math.IsNaN(x)){
+		c.cPlus=math.Max(0,c.cPlus+x-(c.target+c.opts.K*c.sigma))
+		c.cMinus=math.Max(0,c.cMinus+(c.target-c.opts.K*c.sigma)-x)
+		if (c.cPlus == 0){
+			c.plusStart=c.index
+		}
+		if (c.cMinus == 0){
+			c.minusStart=c.index
+		}
+	}
+	state.CPlus=c.cPlus
+	state.CMinus=c.cMinus
+
+	limit:=c.opts.H*c.sigma
+	switch {
+	case c.cPlus > limit:
+		state.Direction=1
+		state.ChgStart=c.plusStart
+	case c.cMinus > limit:
+		state.Direction=-1
+		state.ChgStart=c.minusStart
+	default:
+		return state, nil
+	}
+	state.Signal=true
+
+	c.restart()
+	if (c.opts.Recalibrate){
+		c.calib=make([]float64,0,c.opts.Calibrate)
+	}
+
+	return state, nil
+}
+
+func (c *Chart) Target()(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the target mean in use, NaN while calibrating
+	//	Input:
+	//	Output:  target
+	//-----------------------------------------------------------------------------------
+
+	if (c.calib != nil){
+		return math.NaN()
+	}
+
+	return c.target
+}
+
+func (c *Chart) Sigma()(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the standard deviation in use, NaN while calibrating
+	//	Input:
+	//	Output:  sigma
+	//-----------------------------------------------------------------------------------
+
+	if (c.calib != nil){
+		return math.NaN()
+	}
+
+	return c.sigma
+}
@@ -2,16 +2,14 @@ package cpd
 
 
 import (
-    "bufio" 
-    "os"
-    "strings"
-    "encoding/csv"
-    "strconv"
-    "io"
+    "context"
+    "errors"
     "fmt"
     "math"
     "math/rand"
     "sort"
+    "sync"
+    "time"
 )
 
 // ///////////////////// CONSTANTS
@@ -39,16 +37,45 @@ type CoordT struct {
 type ChgT struct {
     Index int64
     Conf  float64
+    Iterations int64 // bootstrap shuffles used to test the change
     Avg   float64
     Stdev float64
-    ChgStartLine  int64
+    Median float64
+    MAD   float64 // median absolute deviation, unscaled
+    ChgStartLine  int64 // line in the source file, index+1 for data without lines
     ChgEndLine    int64
+    ChgStartIndex int64 // sample index, 0-based, end inclusive
+    ChgEndIndex   int64
     ChgStartTime  string
     ChgEndTime    string
+    ChgStartTS    time.Time // zero unless the time column was parsed
+    ChgEndTS      time.Time
     ChgStartValue float64
     ChgEndValue   float64
     Subtle bool
     PrevChgIndex int64
+    Gap bool // change forced by a gap in the time stamps (GAP_SPLIT)
+    Changed int // CHG_MEAN, CHG_VAR and/or CHG_SLOPE, what the change was found in
+    Strength float64 // test statistic: normalized cusum range, share of trend residuals
+                     // explained, or for PELT the cost saved; ranks equally confident
+                     // changes for MaxChanges
+
+    //statistical report, relative to the previous segment
+    PValue     float64 // bootstrap p-value, 1 for untested boundaries, NaN for PELT
+    Shift      float64 // Avg minus the previous Avg
+    ShiftPct   float64 // Shift as a percentage of the previous Avg, NaN if it is 0
+    Direction  int     // +1 up, -1 down, 0 no shift
+    EffectSize float64 // Cohen's d, NaN if both segments are flat
+    VarRatio   float64 // variance over the previous variance, NaN if that is 0
+
+    //fitted line, y = Intercept + Slope*index
+    Slope      float64 // per sample
+    Intercept  float64
+    Angle      float64 // degrees, as seen on a square plot of the whole series
+    TrendStart CoordT  // line at the first and last sample
+    TrendEnd   CoordT
+    AvgLow     float64 // interval for Avg at the MinConf level
+    AvgHigh    float64
 } 
 
 type RangeT struct{
@@ -70,24 +97,93 @@ type RangeGroupT struct{
     RangeA []RangeT `json:"range"`
 }
 
+// Options controls the change analysis.  Zero valued fields take the package
+// defaults; a negative ChgTolerance disables merging of subtle changes.
+type Options struct {
+    Bootstrap    int64   // shuffles used to test each candidate change
+    MinConf      float64 // minimum confidence (percent) to accept a change
+    ChgTolerance int     // changes within this percentage are merged
+    Merge        int     // MERGE_RATIO, MERGE_ABS, MERGE_SIGMA, MERGE_WELCH or MERGE_BOOT
+    MergeThresh  float64 // difference, deviations or significance, see SetMerge
+    Workers      int     // bootstrap goroutines, 0 for GOMAXPROCS
+    EarlyStop    bool    // end each bootstrap once the outcome is clear
+    BootMode     int     // BOOT_SHUFFLE, BOOT_BLOCK or BOOT_STATIONARY
+    BlockLen     int     // (mean) block length, 0 to choose per segment
+
+    // limits, see SetMinSegment, SetMaxChanges and SetMaxDepth
+    MinLen       int           // fewest samples in a segment
+    MinDuration  time.Duration // shortest segment, with time stamps
+    MaxChanges   int           // most changes kept, the most confident, 0 for all
+    MaxDepth     int           // deepest binary segmentation, 0 for no limit
+    Target       int     // TARGET_MEAN, TARGET_VAR, TARGET_MEANVAR or TARGET_TREND
+    Robust       bool    // rank based tests, segments judged by Median and MAD
+
+    // spike pre-pass, see SetSpikes
+    Spikes       int     // SPIKE_KEEP, SPIKE_EXCLUDE or SPIKE_WINSORIZE
+    SpikeLen     int     // longest spike in samples
+    SpikeThresh  float64 // distance from the local median in scaled MADs
+
+    // detector, see SetMethod, SetCost and SetPenalty
+    Method       int
+    Cost         int     // PELT segment cost
+    Penalty      int     // PELT penalty per change
+    PenaltyValue float64 // PEN_MANUAL penalty
+
+    // randomness, see SetSeed; with neither set a random seed is drawn
+    Seed         int64       // seed for all random draws, 0 for none
+    Source       rand.Source // seed drawn from this source when Seed is 0
+}
+
+// Result holds the outcome of one analysis.  Changes lists every change point
+// found, Segments the summary after subtle changes were merged.
+type Result struct {
+    Column   string
+    Seed     int64 // rerun with this seed to reproduce the result
+    Changes  []ChgT
+    Segments []ChgT
+    Anomalies []AnomalyT // spikes found by the pre-pass
+}
+
 type ChgA  []ChgT 
 type PatternA  []PatternT
 type DataT []float64
 type TimeT []string
 
+// Detector owns the configuration, loaded data and results of one analysis.
+// Independent Detectors may be used concurrently.
+type Detector struct {
+    chgA ChgA
+    matchA PatternA
+    chgAPost ChgA
+    anomalies []AnomalyT
+    load LoadOptions
+    column string
+    report LoadReport
+    rawData DataT
+    timeData TimeT
+    timeLabels bool // timeData holds time labels rather than sample numbers
+    stampData []time.Time
+    lineData []int64
+    breaks []int64
+    opts Options
+    matchStrList map[string]struct{}
+
+    //per analysis
+    seed int64
+    pool *bootPool
+    angleScale float64
+    mu sync.Mutex
+}
+
 
 // ///////////////////// GLOBALS
-var G_chgA ChgA 
-var G_matchA PatternA 
-var G_chgAPost ChgA
-var G_timeCol,G_dataCol int32
-var G_delim rune
-var G_rawData DataT
-var G_timeData TimeT
-var G_minConf float64
-var G_bootstrap int64
-var G_chgTolerance int
-var G_matchStrList map[string]struct{}
+var G_detector *Detector
+
+// ///////////////////// ERRORS
+var ErrNoData = errors.New("cpd: no data to analyze")
+var ErrLabelCount = errors.New("cpd: label count does not match data count")
+var ErrBadValue = errors.New("cpd: data contains infinite values")
+var ErrBadOption = errors.New("cpd: invalid option")
 
 
 //custom sorting functions
@@ -107,103 +203,164 @@ func (slice ChgA) Swap(i, j int) {
 func calcAvg(data []float64)( float64){
 
 	//-----------------------------------------------------------------------------------
-	//  Simple average calculation.  
+	//  Simple average calculation, NaN (missing) values are skipped
 	//	Input:   array of floats
 	//	Output:  float avg
 	//-----------------------------------------------------------------------------------
 
         //variables
         var sum float64
+        var count int
 
         //init
         sum=0
+        count=0
 
         for _, value := range data {
+                if (math.IsNaN(value)){
+                        continue
+                }
                 sum=sum+value
+                count=count+1
         }
 
-        return(sum/((float64)(len(data))))
+        return(sum/((float64)(count)))
 }
 
 func calcStdev(data []float64, avg float64 )(float64){
 
 	//-----------------------------------------------------------------------------------
-	//  Calculates standard deviation      
+	//  Calculates standard deviation, NaN (missing) values are skipped
 	//	Input:  array of floats, pre-calculated average
 	//	Output: stdev as float
 	//-----------------------------------------------------------------------------------
 
         var sd float64
+        var count int
 
         for _, value := range data{
+                if (math.IsNaN(value)){
+                        continue
+                }
                 sd += math.Pow(value - avg , 2)
+                count=count+1
         }
 
-        sd = math.Sqrt(sd/float64(len(data)))
+        sd = math.Sqrt(sd/float64(count))
 
         return sd
 }
 
-func calcCusum(avg float64, data []float64) (float64, int64) {
+func calcMedian(data []float64)(float64){
 
 	//-----------------------------------------------------------------------------------
-	//  Calculates cusum, returning delta between max and min cusum values, along with 
-	//  array index of max or min value
-	//	Input:  avg, data to analyze
-	//	Output: max/min delta, index of max or min
+	//  Median, NaN values are skipped
+	//	Input:   data
+	//	Output:  median, NaN for no values
 	//-----------------------------------------------------------------------------------
 
-        //variables
-        var cusumA []float64
-        var maxC,minC float64
-        var peakIndex int64
-        var peak float64
-        cusumA=append(cusumA,0.0)
+	var sorted []float64
 
-        //init
-        minC=(float64)(MaxInt)
-        maxC=(float64)(MinInt)
-        peak=0.0
-        peakIndex=0
-
-        //calculate the cusum, min and max diff
-        for dataIndex := range data{
-                cusumA=append(cusumA,cusumA[dataIndex]+(data[dataIndex]-avg))
-                if (cusumA[dataIndex+1] > maxC){
-                        maxC=cusumA[dataIndex+1]
-                }
-                if (cusumA[dataIndex+1] < minC){
-                        minC=cusumA[dataIndex+1]
-                }
+	for _, value := range data{
+		if (!math.IsNaN(value)){
+			sorted=append(sorted,value)
+		}
+	}
+	if (len(sorted) == 0){
+		return math.NaN()
+	}
+	sort.Float64s(sorted)
 
-                if (cusumA[dataIndex+1] > 0){
-                        if (cusumA[dataIndex+1] > peak){
-                                peak=cusumA[dataIndex+1]
-                                peakIndex=int64(dataIndex)
-                        }
-                }else{
-                        if ( (cusumA[dataIndex+1]*(-1)) > peak){
-                                peak=cusumA[dataIndex+1]*(-1)
-                                peakIndex=int64(dataIndex)
-                        }
-                }
-        }
+	return quantile(sorted,0.5)
+}
+
+func calcMAD(data []float64, median float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Median absolute deviation from the median (unscaled), NaN values are skipped
+	//	Input:   data, pre-calculated median
+	//	Output:  MAD
+	//-----------------------------------------------------------------------------------
+
+	dev:=make([]float64,0,len(data))
+	for _, value := range data{
+		if (!math.IsNaN(value)){
+			dev=append(dev,math.Abs(value-median))
+		}
+	}
+
+	return calcMedian(dev)
+}
+
+func calcCusumIn(avg float64, data []float64, lo, hi int64)(float64, int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Calculates cusum, returning delta between max and min cusum values, along with
+	//  array index of max or min value, over the split positions lo..hi only so a
+	//  change is never placed where a segment would be too short.  NaN (missing)
+	//  values leave the cusum as is; a window of missing values has no range.
+	//  Single pass and allocation free, it runs once per bootstrap shuffle
+	//	Input:  avg, data to analyze, first and last allowed index of the last
+	//		sample before a change
+	//	Output: max/min delta within the window, index of max or min
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var cusum,peak float64
+
+	minC:=(float64)(MaxInt)
+	maxC:=(float64)(MinInt)
+	peakIndex:=lo
+
+	for dataIndex, value := range data{
+		if (int64(dataIndex) > hi){
+			break
+		}
+		if (math.IsNaN(value)){
+			continue
+		}
+		cusum=cusum+(value-avg)
+		if (int64(dataIndex) < lo){
+			continue
+		}
+		if (cusum > maxC){
+			maxC=cusum
+		}
+		if (cusum < minC){
+			minC=cusum
+		}
+		if (math.Abs(cusum) > peak){
+			peak=math.Abs(cusum)
+			peakIndex=int64(dataIndex)
+		}
+	}
+	if (maxC < minC){
+		return 0, peakIndex
+	}
 
-        return maxC-minC,peakIndex
+	return maxC-minC,peakIndex
 }
 
-func SetBootstrapLimit(bootstrap int64){
+func (d *Detector) SetBootstrapLimit(bootstrap int64){
 
 	//-----------------------------------------------------------------------------------
 	//  Sets the bootstrap number; the limit at which a point is tested for a change
-	//	Input:   bootstrap count
+	//	Input:   bootstrap count (0 for DEF_BOOTSTRAP, negative counts are clamped
+	//		 to 1)
 	//	Output:  
 	//-----------------------------------------------------------------------------------
 
-	G_bootstrap=bootstrap
+	if (bootstrap == 0){
+		bootstrap=DEF_BOOTSTRAP
+	}
+	if (bootstrap < 1){
+		bootstrap=1
+	}
+
+	d.opts.Bootstrap=bootstrap
 }
 
-func SetTimeCol(timeCol int32){
+func (d *Detector) SetTimeCol(timeCol int32){
 
 	//-----------------------------------------------------------------------------------
 	//  Specifies which column of data contains time of day
@@ -213,11 +370,12 @@ func SetTimeCol(timeCol int32){
 
 	//if valid col:
 	if (timeCol > 0){
-		G_timeCol=timeCol
+		d.load.TimeCol=timeCol
+		d.load.TimeColName=""
 	}
 }
 
-func SetDataCol(dataCol int32){
+func (d *Detector) SetDataCol(dataCol int32){
 
 	//-----------------------------------------------------------------------------------
 	//  Updates global var to denote which col has the data
@@ -227,11 +385,12 @@ func SetDataCol(dataCol int32){
 
 	//if valid col:
 	if (dataCol > 0){
-		G_dataCol=dataCol
+		d.load.DataCol=dataCol
+		d.load.DataColName=""
 	}
 }
 
-func SetTimeNDataCols(timeCol, dataCol int32) {
+func (d *Detector) SetTimeNDataCols(timeCol, dataCol int32) {
 
 	//-----------------------------------------------------------------------------------
 	//  Updates global var to denote which col has the data
@@ -239,11 +398,11 @@ func SetTimeNDataCols(timeCol, dataCol int32) {
 	//	Output:  
 	//-----------------------------------------------------------------------------------
 
-	SetTimeCol(timeCol)
-	SetDataCol(dataCol)
+	d.SetTimeCol(timeCol)
+	d.SetDataCol(dataCol)
 }
 
-func SetChgTolerance(i int){
+func (d *Detector) SetChgTolerance(i int){
 
 	//-----------------------------------------------------------------------------------
 	//  Sets the change tolerance.  Not considered a change if < %tolerance.  Whole number
@@ -252,10 +411,10 @@ func SetChgTolerance(i int){
 	//	Output:  
 	//-----------------------------------------------------------------------------------
 
-	G_chgTolerance=i
+	d.opts.ChgTolerance=i
 }
 
-func NoTimeCol() {
+func (d *Detector) NoTimeCol() {
 
 	//-----------------------------------------------------------------------------------
 	//  Flag that data file does not have a data column.  Output will be numbered instead
@@ -263,11 +422,12 @@ func NoTimeCol() {
 	//	Output:  
 	//-----------------------------------------------------------------------------------
 
-	G_timeCol=NO_TIME_COL
+	d.load.TimeCol=NO_TIME_COL
+	d.load.TimeColName=""
 }
 
 
-func SetDelim(delim rune){
+func (d *Detector) SetDelim(delim rune){
 
 	//-----------------------------------------------------------------------------------
 	//  Specifies the delimiter in the data file
@@ -275,137 +435,35 @@ func SetDelim(delim rune){
 	//	Output:  
 	//-----------------------------------------------------------------------------------
 
-	G_delim=delim
-}
-
-func rollBackData( errCol int32, goodRow int64){
-
-        //-----------------------------------------------------------------------------------
-        //  If parsing or any other error found, delete data from global structs, preventing
-	//  partial data entries
-        //      Input:   column where error occured, row of last good data
-        //      Output:  
-        //-----------------------------------------------------------------------------------
-
-	if (G_dataCol < errCol){
-		G_rawData=append(DataT(nil), G_rawData[:goodRow]...)
-	}
-
-	if (G_timeCol < errCol){
-		G_timeData=append(TimeT(nil), G_timeData[:goodRow]...)
-	}
-
-}
-
-func _getDataFromFile(fname string, tCol, dCol int32) {
-
-        //-----------------------------------------------------------------------------------
-        //  Takes data from file and populates global structures
-        //      Input:   filename, id for columns that have time stamps and data
-        //      Output:  
-        //-----------------------------------------------------------------------------------
-
-	//var 
-	var f float64
-	var rowCount int64
-
-        // Load file.
-        file, _ := os.Open(fname)
-
-        // Create a new reader.
-        r := csv.NewReader(bufio.NewReader(file))
-
-	//set the delimeter:
-	r.Comma=G_delim
-
-	rowCount=0
-        for {
-                record, err := r.Read()
-                // Stop at EOF.
-                if err == io.EOF {
-                        break
-                }
-
-		if (len(record)>1){
-                	for index := range record {
-
-				//go to next record if already have all necessary data:
-				if (  ( int32(index+1) > G_dataCol ) && ( int32(index+1) > G_timeCol) ){
-					break;
-				}
-
-				//only count once per row
-				if (index == 0){
-					rowCount=rowCount+1
-
-					//no time column
-					if (G_timeCol==NO_TIME_COL){
-						//use incremental values in place of time
-                                       		strRCount:=fmt.Sprintf("%10d",rowCount)
-                                       		G_timeData=append(G_timeData,strRCount)
-					}
-
-				}
-
-				if (int32(index+1)==G_dataCol) {
-
-					f, err = strconv.ParseFloat(strings.TrimSpace(record[index]),64)
-					if (err == nil){
-					
-						G_rawData=append(G_rawData,f)
-
-
-                        		}else{ //invalid record
-						//last row does not count:
-						rowCount=rowCount-1
-				
-						//roll back data stored in arrays	
-						rollBackData(int32(index+1),rowCount)
-
-						//stop processing record
-						break;	
-					}
-				}
-
-                                if (int32(index+1)==G_timeCol) {
-					//use incremental values in place of time
-                                       	G_timeData=append(G_timeData,record[index])
-                                }
-                	}
-		}
-        }
-}
-
-func GetDataFromFile(fname string){
-
-        //-----------------------------------------------------------------------------------
-        //  Takes data from file and populates global structures
-        //      Input:   filename
-        //      Output:  
-        //-----------------------------------------------------------------------------------
-	
-	_getDataFromFile(fname,G_timeCol,G_dataCol)
+	d.load.Delim=delim
 }
 
-
-func findChange(lookRight bool, base_start, base_end, chgPt int64, origData []float64){
+func (d *Detector) findChange(ctx context.Context, lookRight bool, base_start, base_end, chgPt int64, origData []float64, depth int)(error){
 
         //-----------------------------------------------------------------------------------
         //  Recursive function that does the change point analysis. Continues while there are
-	//  segments of the array to be analyzed 
-        //      Input:   direction to look (left/right), start_index, stop_index, 
-	//			last_chgpt, data array
-        //      Output:  
+	//  segments of the array to be analyzed, long enough to split, and MaxDepth allows
+        //      Input:   context, direction to look (left/right), start_index, stop_index, 
+	//			last_chgpt, data array, recursion depth
+        //      Output:  error if the context was cancelled
         //-----------------------------------------------------------------------------------
 
 
 	//var
         var slice []float64
-        var newDelta float64
-        var origDelta float64
         var oneChg ChgT
+        var test testT
+        var err error
 	var lookLeft bool
 
+	//stop if caller gave up
+	if err:=ctx.Err(); err != nil{
+		return err
+	}
+	if (d.opts.MaxDepth > 0) && (depth >= d.opts.MaxDepth){
+		return nil
+	}
+
 	//init - can only look left or right:
 	lookLeft=false
 	if lookRight == false{
@@ -426,59 +484,67 @@ func findChange(lookRight bool, base_start, base_end, chgPt int64, origData []fl
                         base_end=base_start+int64(len(slice)-1)
                 }
 
+                //both sides of a change need MinLen samples, and MinDuration
+                lo, hi := d.splitRange(base_start,base_end)
+                if (lo > hi){
+                        return nil
+                }
 
-                	//get a copy of the data
-                	bootstrap := make([]float64, len(slice), (cap(slice)))
-
-                	//copy contents over
-                	copy(bootstrap,slice)
-
-                	//calculate the average
-                	avg:=calcAvg(slice)
-
-                	//gather original-ordered data cusum
-                	origDelta,chgPt=calcCusum(avg, slice)
-
-                	gtCount:=0
-                	//bootstrap to detect confidence in change
-                	for bootIndex := int64(0); bootIndex < G_bootstrap; bootIndex++ {
-				//random sort the data in slice
-                        	for i := range bootstrap{
-                                	j := rand.Intn(i + 1)
-                                	bootstrap[i], bootstrap[j] = bootstrap[j], bootstrap[i]
-                        	}
-				//get cusum of random ordered data
-                        	newDelta,_=calcCusum(avg, bootstrap)
-
-                        	if (origDelta > newDelta){
-                                	gtCount=gtCount+1
-                        	}
+                	//cusum of the original-ordered data, and bootstrap to detect
+                	//confidence in change
+                	test, err = d.testSegment(ctx,slice,base_start,base_end,lo,hi)
+                	if (err != nil){
+                        	return err
                 	}
+                	chgPt=test.chgPt
 
-                	//calculate change confidence:
-                	conf:=float64(100*(float64(gtCount)/float64(G_bootstrap)))
-
-                	if (conf >= G_minConf){
+                	if (test.conf >= d.opts.MinConf) && (d.segmentOK(base_start,base_start+chgPt)) &&
+                	   (d.segmentOK(base_start+chgPt+1,base_end)){
 
                         	//save off change s
                         	oneChg.Index=chgPt+1+base_start
-                        	oneChg.Conf=conf
-                        	G_chgA=append(G_chgA,oneChg)
+                        	oneChg.Conf=test.conf
+                        	oneChg.Iterations=test.iters
+                        	oneChg.Changed=test.changed
+                        	oneChg.Strength=test.strength
+                        	d.mu.Lock()
+                        	d.chgA=append(d.chgA,oneChg)
+                        	d.mu.Unlock()
 
                         	newOrig := make([]float64, len(slice), (cap(slice)))
                         	copy(newOrig,slice)
 
-				//look left
-                        	findChange(false,base_start,base_end,chgPt+1,newOrig)
+				//without a pool look left, then right
+				if (d.pool == nil){
+                        		if err:=d.findChange(ctx,false,base_start,base_end,chgPt+1,newOrig,depth+1); err != nil{
+						return err
+					}
+                        		return d.findChange(ctx,true, base_start,base_end,chgPt+1,newOrig,depth+1)
+				}
 
-				//look right
-                        	findChange(true, base_start,base_end,chgPt+1,newOrig)
+				//otherwise look both ways at once
+				var leftErr error
+				var wg sync.WaitGroup
+				wg.Add(1)
+				go func(){
+					defer wg.Done()
+					leftErr=d.findChange(ctx,false,base_start,base_end,chgPt+1,newOrig,depth+1)
+				}()
+                        	rightErr:=d.findChange(ctx,true, base_start,base_end,chgPt+1,newOrig,depth+1)
+				wg.Wait()
+
+				if (leftErr != nil){
+					return leftErr
+				}
+				return rightErr
                 	}
 
         }
+
+	return nil
 }
 
-func pass1PostProc(){
+func (d *Detector) pass1PostProc(){
 
         //-----------------------------------------------------------------------------------
         //  Go through all changes, calculating a summary of the change itself, avg, linenum
@@ -488,62 +554,61 @@ func pass1PostProc(){
         //-----------------------------------------------------------------------------------
 
 	var slice[]float64
-	var delta float64
 	var dindex int64
 
         //summarize the changes by updating their records
-        for i := 0; i < (len(G_chgA)-1); i++ {
+        for i := 0; i < (len(d.chgA)-1); i++ {
 
 		//init
-                G_chgA[i].PrevChgIndex=0
+                d.chgA[i].PrevChgIndex=0
 
                 //performance
-                slice=G_rawData[G_chgA[i].Index:G_chgA[i+1].Index]
-                G_chgA[i].Avg=calcAvg(slice)
-                G_chgA[i].Stdev=calcStdev(slice,G_chgA[i].Avg)
+                slice=d.rawData[d.chgA[i].Index:d.chgA[i+1].Index]
+                d.chgA[i].Avg=calcAvg(slice)
+                d.chgA[i].Stdev=calcStdev(slice,d.chgA[i].Avg)
+                d.chgA[i].Median=calcMedian(slice)
+                d.chgA[i].MAD=calcMAD(slice,d.chgA[i].Median)
 
                 //line numbers
-		G_chgA[i].ChgStartLine=G_chgA[i].Index+1
-		G_chgA[i].ChgEndLine=G_chgA[i+1].Index
+		d.chgA[i].ChgStartLine=d.lineAt(d.chgA[i].Index)
+		d.chgA[i].ChgEndLine=d.lineAt(d.chgA[i+1].Index-1)
+		d.chgA[i].ChgStartIndex=d.chgA[i].Index
+		d.chgA[i].ChgEndIndex=d.chgA[i+1].Index-1
 
                 //time
-		G_chgA[i].ChgStartTime=G_timeData[G_chgA[i].Index]
-		G_chgA[i].ChgEndTime=G_timeData[G_chgA[i+1].Index-1]
+		d.chgA[i].ChgStartTime=d.timeData[d.chgA[i].Index]
+		d.chgA[i].ChgEndTime=d.timeData[d.chgA[i+1].Index-1]
+		d.chgA[i].ChgStartTS=d.stampAt(d.chgA[i].Index)
+		d.chgA[i].ChgEndTS=d.stampAt(d.chgA[i+1].Index-1)
 
                 //value
-		G_chgA[i].ChgStartValue=G_rawData[G_chgA[i].Index]
-		G_chgA[i].ChgEndValue=G_rawData[G_chgA[i+1].Index-1]
+		d.chgA[i].ChgStartValue=d.rawData[d.chgA[i].Index]
+		d.chgA[i].ChgEndValue=d.rawData[d.chgA[i+1].Index-1]
 
-		//check if change is too subtle
-		if (i > 0){
+		//trend
+		d.setTrend(&d.chgA[i])
+
+		//check if change is too subtle, never merge across a gap
+		if (i > 0) && (!d.chgA[i].Gap){
 
 			//avoid regression creep, find parent change:
-			if (G_chgA[i-1].PrevChgIndex == 0){
+			if (d.chgA[i-1].PrevChgIndex == 0){
 				dindex=int64(i-1)
 			}else{
-				dindex=G_chgA[i-1].PrevChgIndex
-			}
-
-			//calculate delta
-			delta=(G_chgA[i].Avg/G_chgA[dindex].Avg)	
-			if (G_chgA[i].Avg < G_chgA[dindex].Avg){
-				delta=(G_chgA[i].Avg/G_chgA[dindex].Avg)	
-			}else{
-				delta=(G_chgA[dindex].Avg/G_chgA[i].Avg)	
+				dindex=d.chgA[i-1].PrevChgIndex
 			}
-			delta=math.Abs(100-((delta*100)+0.5))
 
 			//if not enough a change:
-			if (int(delta) <= G_chgTolerance) {
-				G_chgA[i].Subtle=true	
-				G_chgA[i].PrevChgIndex=dindex
+			if (d.isSubtle(int64(i),dindex)) {
+				d.chgA[i].Subtle=true	
+				d.chgA[i].PrevChgIndex=dindex
 			}
 		}
         }
 
 }
 
-func pass2PostProc(){
+func (d *Detector) pass2PostProc(){
 
         //-----------------------------------------------------------------------------------
         //  Go through all changes, merging subtle changes with obvious changes and 
@@ -560,86 +625,421 @@ func pass2PostProc(){
 	pindex=-1
 
         //copy over and re-calculate the final chgA
-        for i := 0; i < (len(G_chgA)-1); i++ {
+        for i := 0; i < (len(d.chgA)-1); i++ {
 
 		//don't process subtle changes
-		if (!G_chgA[i].Subtle){
+		if (!d.chgA[i].Subtle){
 	
-			G_chgAPost=append(G_chgAPost,oneChg)
+			d.chgAPost=append(d.chgAPost,oneChg)
 			pindex=pindex+1
 
 			//look forward until all subtle changes are merged:
 			sindex=int64(i+1)
-			for ; G_chgA[sindex].Subtle && (sindex<int64(len(G_chgA)-1)); sindex++ {}
+			for ; d.chgA[sindex].Subtle && (sindex<int64(len(d.chgA)-1)); sindex++ {}
 
 			
                 	//performance
-                	slice=G_rawData[G_chgA[i].Index:G_chgA[sindex].Index]
-                	G_chgAPost[pindex].Avg=calcAvg(slice)
-                	G_chgAPost[pindex].Stdev=calcStdev(slice,G_chgAPost[pindex].Avg)
+                	slice=d.rawData[d.chgA[i].Index:d.chgA[sindex].Index]
+                	d.chgAPost[pindex].Avg=calcAvg(slice)
+                	d.chgAPost[pindex].Stdev=calcStdev(slice,d.chgAPost[pindex].Avg)
+                	d.chgAPost[pindex].Median=calcMedian(slice)
+                	d.chgAPost[pindex].MAD=calcMAD(slice,d.chgAPost[pindex].Median)
 
                 	//line numbers
-                	G_chgAPost[pindex].ChgStartLine=G_chgA[i].Index+1
-                	G_chgAPost[pindex].ChgEndLine=G_chgA[sindex].Index
+                	d.chgAPost[pindex].ChgStartLine=d.lineAt(d.chgA[i].Index)
+                	d.chgAPost[pindex].ChgEndLine=d.lineAt(d.chgA[sindex].Index-1)
+                	d.chgAPost[pindex].ChgStartIndex=d.chgA[i].Index
+                	d.chgAPost[pindex].ChgEndIndex=d.chgA[sindex].Index-1
 
                 	//time
-                	G_chgAPost[pindex].ChgStartTime=G_timeData[G_chgA[i].Index]
-                	G_chgAPost[pindex].ChgEndTime=G_timeData[G_chgA[sindex].Index-1]
+                	d.chgAPost[pindex].ChgStartTime=d.timeData[d.chgA[i].Index]
+                	d.chgAPost[pindex].ChgEndTime=d.timeData[d.chgA[sindex].Index-1]
+                	d.chgAPost[pindex].ChgStartTS=d.stampAt(d.chgA[i].Index)
+                	d.chgAPost[pindex].ChgEndTS=d.stampAt(d.chgA[sindex].Index-1)
 
                 	//value
-                	G_chgAPost[pindex].ChgStartValue=G_rawData[G_chgA[i].Index]
-                	G_chgAPost[pindex].ChgEndValue=G_rawData[G_chgA[sindex].Index-1]
+                	d.chgAPost[pindex].ChgStartValue=d.rawData[d.chgA[i].Index]
+                	d.chgAPost[pindex].ChgEndValue=d.rawData[d.chgA[sindex].Index-1]
+
+                	//trend
+                	d.setTrend(&d.chgAPost[pindex])
 
                 	//conf
-                	G_chgAPost[pindex].Conf=G_chgA[i].Conf
+                	d.chgAPost[pindex].Conf=d.chgA[i].Conf
+                	d.chgAPost[pindex].Iterations=d.chgA[i].Iterations
+                	d.chgAPost[pindex].Index=d.chgA[i].Index
+                	d.chgAPost[pindex].Gap=d.chgA[i].Gap
+                	d.chgAPost[pindex].Changed=d.chgA[i].Changed
 		}
         }
 }
 
-func FindChange(){
+func (d *Detector) analyze(ctx context.Context)(error){
 
 	//-----------------------------------------------------------------------------------
 	//  Finds the changes in data, stores them in a struct, then calls other functions
 	//  to summarize the changes found. 	 
-	//	Input:   
-	//	Output:  update change struct
+	//	Input:   context
+	//	Output:  update change struct, error if no data or cancelled
 	//-----------------------------------------------------------------------------------
 
         var oneChg ChgT
 
+	d.chgA=d.chgA[:0]
+	d.chgAPost=d.chgAPost[:0]
+	d.anomalies=nil
+
         //init for change detection
-        chgPt:=int64(len(G_rawData))
-	if (chgPt > 0){
+        chgPt:=int64(len(d.rawData))
+	if (chgPt == 0){
+		return ErrNoData
+	}
+
+	//spikes are taken out of a copy, the loaded data is put back when done
+	if (d.opts.Spikes != SPIKE_KEEP){
+		raw:=d.rawData
+		d.rawData=d.findSpikes(raw)
+		defer func(){
+			d.rawData=raw
+		}()
+	}
+
+       	//load init changes (beginning and dummy_end)
+       	oneChg.Index=0 
+	oneChg.Conf=0
+       	d.chgA=append(d.chgA,oneChg)
+
+	//dummy data point at length_of_data_+1
+       	oneChg.Index=int64(len(d.rawData))
+       	oneChg.Conf=0
+       	d.chgA=append(d.chgA,oneChg)
+
+	//random draws derive from one seed, spread over the workers
+	switch {
+	case d.opts.Seed != 0:
+		d.seed=d.opts.Seed
+	case d.opts.Source != nil:
+		d.seed=d.opts.Source.Int63()
+	default:
+		d.seed=rand.Int63()
+	}
+	if workers:=workerCount(d.opts.Workers); workers > 1{
+		d.pool=newBootPool(workers)
+		defer func(){
+			d.pool.close()
+			d.pool=nil
+		}()
+	}
+
+	//gaps split the data, each piece is analyzed on its own
+	start:=int64(0)
+	for _, brk := range append(d.breaks,chgPt){
+		if (brk <= start) || (brk > chgPt){
+			continue
+		}
+		if (brk < chgPt){
+			oneChg.Index=brk
+			oneChg.Gap=true
+			d.chgA=append(d.chgA,oneChg)
+		}
+
+		if err:=d.detect(ctx,start,brk); err != nil{
+			d.chgA=d.chgA[:0]
+			return err
+		}
+		start=brk
+	}
+
+       	//sort change points by index, then apply the limits
+       	sort.Sort(d.chgA)
+	d.limitChanges()
+	d.angleScale=angleScale(d.rawData)
+
+	//populate struct, flagging subtle changes
+	d.pass1PostProc()
+
+	//populate struct summarizing changes that occured
+	d.pass2PostProc()
+
+	//add p-values, shifts, effect sizes and intervals
+	d.statsPass(d.chgA[:len(d.chgA)-1])
+	d.statsPass(d.chgAPost)
+	d.segmentCIs(d.chgA[:len(d.chgA)-1],d.chgAPost)
+
+	//remove dummy change (last change point at len_of_data+1)
+	d.chgA=d.chgA[:len(d.chgA)-1]
+
+	return nil
+}
+
+func (d *Detector) detect(ctx context.Context, start, end int64)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Finds the changes within data[start:end] with the selected method
+	//	Input:   context, piece bounds, end exclusive
+	//	Output:  change struct updated, error if cancelled
+	//-----------------------------------------------------------------------------------
+
+	switch (d.opts.Method){
+	case METHOD_PELT:
+		return d.findChangePELT(ctx,start,end)
+	}
+
+	return d.findChange(ctx,false,start,end-1,end-start,d.rawData[start:end],0)
+}
+
+func (d *Detector) FindChange(){
+
+	//-----------------------------------------------------------------------------------
+	//  Finds the changes in the loaded data.  Nothing is reported if no data is loaded
+	//	Input:   
+	//	Output:  update change struct
+	//-----------------------------------------------------------------------------------
+
+	d.analyze(context.Background())
+}
 
-		G_chgA=G_chgA[:0]
+func (d *Detector) Analyze(ctx context.Context)(*Result, error){
 
-        	//load init changes (beginning and dummy_end)
-        	oneChg.Index=0 
-		oneChg.Conf=0
-        	G_chgA=append(G_chgA,oneChg)
+	//-----------------------------------------------------------------------------------
+	//  Finds the changes in the loaded data and returns a copy of the results
+	//	Input:   context, checked while bootstrapping
+	//	Output:  all and merged changes, error if no data or cancelled
+	//-----------------------------------------------------------------------------------
+
+	if err:=d.analyze(ctx); err != nil{
+		return nil, err
+	}
+
+	res:=new(Result)
+	res.Column=d.column
+	res.Seed=d.seed
+	res.Changes=append([]ChgT(nil), d.chgA...)
+	res.Segments=append([]ChgT(nil), d.chgAPost...)
+	res.Anomalies=append([]AnomalyT(nil), d.anomalies...)
+
+	return res, nil
+}
+
+func (d *Detector) SetOptions(opts Options)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Replaces the analysis options, zero valued fields take the defaults
+	//	Input:   options
+	//	Output:  error if an option is out of range
+	//-----------------------------------------------------------------------------------
+
+	opts=opts.withDefaults()
+	if err:=opts.validate(); err != nil{
+		return err
+	}
+
+	d.opts=opts
+	return nil
+}
+
+func (d *Detector) SetData(data []float64, labels []string)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Replaces the loaded data.  Without labels the samples are numbered, as is
+	//  done for files without a time column.  NaN values are treated as missing
+	//	Input:   data, optional labels (one per data point)
+	//	Output:  error if data is empty, non-finite or labels do not match
+	//-----------------------------------------------------------------------------------
+
+	if (len(data) == 0){
+		return ErrNoData
+	}
+	if (labels != nil) && (len(labels) != len(data)){
+		return ErrLabelCount
+	}
+	for _, value := range data{
+		if math.IsInf(value,0){
+			return ErrBadValue
+		}
+	}
+
+	d.rawData=append(DataT(nil), data...)
+	d.stampData=nil
+	d.lineData=nil
+	d.breaks=nil
+	d.column=""
+	d.timeLabels=(labels != nil)
+	if (labels != nil){
+		d.timeData=append(TimeT(nil), labels...)
+	}else{
+		d.timeData=make(TimeT,len(data))
+		for i := range data{
+			d.timeData[i]=fmt.Sprintf("%10d",i+1)
+		}
+	}
+
+	return nil
+}
+
+func (d *Detector) SetColumnName(name string){
+
+	//-----------------------------------------------------------------------------------
+	//  Names the analyzed data, the name is carried to printed and returned results
+	//	Input:   column name
+	//	Output:  
+	//-----------------------------------------------------------------------------------
+
+	d.column=name
+}
+
+func Analyze(ctx context.Context, series []float64, labels []string, opts Options)(*Result, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Runs the change analysis on a series held in memory, without touching the
+	//  default detector
+	//	Input:   context, data, optional labels (nil to number samples), options
+	//	Output:  all and merged changes, error on bad input or cancellation
+	//-----------------------------------------------------------------------------------
+
+	d:=NewDetector()
+	if err:=d.SetOptions(opts); err != nil{
+		return nil, err
+	}
+	if err:=d.SetData(series,labels); err != nil{
+		return nil, err
+	}
+
+	return d.Analyze(ctx)
+}
+
+func AnalyzeSeries(ctx context.Context, series *Series, opts Options)(*Result, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Runs the change analysis on a loaded series, keeping its column name
+	//	Input:   context, series from LoadCSV, options
+	//	Output:  all and merged changes, error on bad input or cancellation
+	//-----------------------------------------------------------------------------------
+
+	d:=NewDetector()
+	if err:=d.SetOptions(opts); err != nil{
+		return nil, err
+	}
+	if err:=d.SetData(series.Data,series.Time); err != nil{
+		return nil, err
+	}
+	if err:=d.SetStamps(series.Stamps); err != nil{
+		return nil, err
+	}
+	if err:=d.SetLines(series.Lines); err != nil{
+		return nil, err
+	}
+	d.SetBreaks(series.Breaks)
+	d.SetColumnName(series.Name)
+	d.timeLabels=(series.TimeCol != NO_TIME_COL)
+
+	return d.Analyze(ctx)
+}
 
-		//dummy data point at length_of_data_+1
-        	oneChg.Index=int64(len(G_rawData))
-        	oneChg.Conf=0
-        	G_chgA=append(G_chgA,oneChg)
+func AnalyzeColumns(ctx context.Context, seriesA []*Series, opts Options)([]*Result, error){
 
-        	findChange(false,0,int64(len(G_rawData)-1),chgPt,G_rawData)
+	//-----------------------------------------------------------------------------------
+	//  Runs the change analysis on each series, typically the columns of one file
+	//	Input:   context, series from LoadCSVColumns or GetColumnsFromFile, options
+	//	Output:  one result per series, in the same order; error naming the column
+	//		 that failed
+	//-----------------------------------------------------------------------------------
 
-        	//sort change points by index
-        	sort.Sort(G_chgA)
+	var resA []*Result
+
+	for _, series := range seriesA{
+		res, err := AnalyzeSeries(ctx,series,opts)
+		if (err != nil){
+			return nil, fmt.Errorf("column %q: %w", series.Name, err)
+		}
+		resA=append(resA,res)
+	}
+
+	return resA, nil
+}
+
+func (opts Options) withDefaults()(Options){
+
+	//-----------------------------------------------------------------------------------
+	//  Fills in the defaults for all zero valued options
+	//	Input:   
+	//	Output:  completed options
+	//-----------------------------------------------------------------------------------
+
+	if (opts.Bootstrap == 0){
+		opts.Bootstrap=DEF_BOOTSTRAP
+	}
+	if (opts.MinConf == 0){
+		opts.MinConf=DEF_MIN_CONF
+	}
+	if (opts.ChgTolerance == 0){
+		opts.ChgTolerance=DEF_CHG_TOLERANCE
+	}
+	if (opts.MinLen == 0){
+		opts.MinLen=DEF_MIN_INTERVAL
+	}
+	if (opts.SpikeLen == 0){
+		opts.SpikeLen=DEF_SPIKE_LEN
+	}
+	if (opts.SpikeThresh == 0){
+		opts.SpikeThresh=DEF_SPIKE_THRESH
+	}
+
+	return opts
+}
 
-		//populate struct, flagging subtle changes
-		pass1PostProc()
+func (opts Options) validate()(error){
 
-		//populate struct summarizing changes that occured
-		pass2PostProc()
+	//-----------------------------------------------------------------------------------
+	//  Checks the options are in range
+	//	Input:   
+	//	Output:  error describing the first bad option
+	//-----------------------------------------------------------------------------------
 
-		//remove dummy change (last change point at len_of_data+1)
-		G_chgA=G_chgA[:len(G_chgA)-1]
+	if (opts.Bootstrap < 0){
+		return fmt.Errorf("%w: bootstrap count %d", ErrBadOption, opts.Bootstrap)
+	}
+	if (opts.MinConf < 0) || (opts.MinConf > 100){
+		return fmt.Errorf("%w: min confidence %v", ErrBadOption, opts.MinConf)
+	}
+	if (opts.ChgTolerance > 100){
+		return fmt.Errorf("%w: change tolerance %d", ErrBadOption, opts.ChgTolerance)
+	}
+	if (opts.Workers < 0){
+		return fmt.Errorf("%w: worker count %d", ErrBadOption, opts.Workers)
+	}
+	if (opts.Target < TARGET_MEAN) || (opts.Target > TARGET_TREND){
+		return fmt.Errorf("%w: target %d", ErrBadOption, opts.Target)
+	}
+	if err:=opts.validateLimits(); err != nil{
+		return err
+	}
+	if err:=opts.validateMerge(); err != nil{
+		return err
+	}
+	if err:=opts.validateBlock(); err != nil{
+		return err
 	}
+	if (opts.Spikes < SPIKE_KEEP) || (opts.Spikes > SPIKE_WINSORIZE){
+		return fmt.Errorf("%w: spike policy %d", ErrBadOption, opts.Spikes)
+	}
+	if (opts.SpikeLen < 0) || (opts.SpikeThresh < 0){
+		return fmt.Errorf("%w: spike length %d, threshold %v", ErrBadOption, opts.SpikeLen, opts.SpikeThresh)
+	}
+	if (opts.Method < METHOD_BINSEG) || (opts.Method > METHOD_PELT){
+		return fmt.Errorf("%w: method %d", ErrBadOption, opts.Method)
+	}
+	if (opts.Cost < COST_NORMAL_MEAN) || (opts.Cost > COST_NONPARAM){
+		return fmt.Errorf("%w: cost %d", ErrBadOption, opts.Cost)
+	}
+	if (opts.Penalty < PEN_MBIC) || (opts.Penalty > PEN_MANUAL) || (opts.PenaltyValue < 0){
+		return fmt.Errorf("%w: penalty %d (%v)", ErrBadOption, opts.Penalty, opts.PenaltyValue)
+	}
+
+	return nil
 }
 
-func GetAllChanges()([]ChgT){
+func (d *Detector) GetAllChanges()([]ChgT){
 
 	//-----------------------------------------------------------------------------------
 	//  Returns the structure containing information of all the detected changes
@@ -647,10 +1047,68 @@ func GetAllChanges()([]ChgT){
 	//	Output:  array of structs describing changes
 	//-----------------------------------------------------------------------------------
 
-	return G_chgA
+	return d.chgA
+}
+
+func (d *Detector) GetSegments()([]ChgT){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the segments left after subtle changes were merged, as printed by
+	//  PrintChg
+	//	Input:   
+	//	Output:  array of structs describing segments
+	//-----------------------------------------------------------------------------------
+
+	return d.chgAPost
+}
+
+func (d *Detector) chgEnd(chgIndex int64)(int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the data index just past the end of a change
+	//	Input:   change index 
+	//	Output:  index of the next change, or the data length for the last one
+	//-----------------------------------------------------------------------------------
+
+	if (chgIndex+1 < int64(len(d.chgA))){
+		return d.chgA[chgIndex+1].Index
+	}
+
+	return int64(len(d.rawData))
+}
+
+func (d *Detector) SetLines(lines []int64)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Attaches source line numbers to the data set by SetData, one per data point
+	//	Input:   line numbers, nil to number the data points from 1
+	//	Output:  error if the count does not match the data
+	//-----------------------------------------------------------------------------------
+
+	if (lines != nil) && (len(lines) != len(d.rawData)){
+		return ErrLabelCount
+	}
+
+	d.lineData=append([]int64(nil), lines...)
+	return nil
+}
+
+func (d *Detector) lineAt(index int64)(int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the source line of a data point
+	//	Input:   data index 
+	//	Output:  line in the loaded file, or index+1 for data without lines
+	//-----------------------------------------------------------------------------------
+
+	if (len(d.lineData) != len(d.rawData)) || (index < 0) || (index >= int64(len(d.lineData))){
+		return index+1
+	}
+
+	return d.lineData[index]
 }
 
-func GetChgDataVal(chgIndex int64)(DataT){
+func (d *Detector) GetChgDataVal(chgIndex int64)(DataT){
 
 	//-----------------------------------------------------------------------------------
 	//  Returns the data value at index
@@ -660,15 +1118,15 @@ func GetChgDataVal(chgIndex int64)(DataT){
 
 	var subset DataT
 
-	if (chgIndex>=0) && (chgIndex<int64(len(G_chgA))){
-		subset=G_rawData[(G_chgA[chgIndex].ChgStartLine-1):G_chgA[chgIndex].ChgEndLine]
+	if (chgIndex>=0) && (chgIndex<int64(len(d.chgA))){
+		subset=d.rawData[d.chgA[chgIndex].Index:d.chgEnd(chgIndex)]
 		return subset
 	}
 
 	return subset
 }
 
-func GetChgTimeVal(chgIndex int64)(TimeT){
+func (d *Detector) GetChgTimeVal(chgIndex int64)(TimeT){
 
 	//-----------------------------------------------------------------------------------
 	//  Returns the time value at index
@@ -678,15 +1136,15 @@ func GetChgTimeVal(chgIndex int64)(TimeT){
 
 	var subset TimeT
 
-	if (chgIndex>=0) && (chgIndex<int64(len(G_chgA))){
-		subset=G_timeData[(G_chgA[chgIndex].ChgStartLine-1):G_chgA[chgIndex].ChgEndLine]
+	if (chgIndex>=0) && (chgIndex<int64(len(d.chgA))){
+		subset=d.timeData[d.chgA[chgIndex].Index:d.chgEnd(chgIndex)]
 		return subset
 	}
 
 	return subset
 }
 
-func GetChgCount()(int){
+func (d *Detector) GetChgCount()(int){
 
 	//-----------------------------------------------------------------------------------
 	//  Find the number of changes found 
@@ -694,10 +1152,10 @@ func GetChgCount()(int){
 	//	Output:  number of change detected
 	//-----------------------------------------------------------------------------------
 
-	return len(G_chgA)
+	return len(d.chgA)
 }
 
-func _printChg(i int, indent bool){
+func (d *Detector) _printChg(i int, indent bool){
 
 	//-----------------------------------------------------------------------------------
 	//  Prints a change given an index
@@ -707,22 +1165,61 @@ func _printChg(i int, indent bool){
 
 	lineStr:="" 
 
-	if G_timeCol==NO_TIME_COL{
-		lineStr=fmt.Sprintf("Line Num: %04d -> %04d",G_chgAPost[i].ChgStartLine, G_chgAPost[i].ChgEndLine)
+	if (!d.timeLabels){
+		lineStr=fmt.Sprintf("Line Num: %04d -> %04d",d.chgAPost[i].ChgStartLine, d.chgAPost[i].ChgEndLine)
 	}else{
-		lineStr=fmt.Sprintf("Time: %v -> %v", G_chgA[i].ChgStartTime,G_chgA[i].ChgEndTime)
+		lineStr=fmt.Sprintf("Time: %v -> %v", d.chgAPost[i].ChgStartTime,d.chgAPost[i].ChgEndTime)
 	}
 
 
 	indentStr:="     "
 
-	fmt.Printf("%sChg:%04d  ,  %s  len=%04d  ,  Avg:%#.2f, Stdev:%#.2f  ,  Chg. Conf %5.1f%% @: %d\n",
+	//robust mode reports medians
+	levelStr:=fmt.Sprintf("Avg:%#.2f, Stdev:%#.2f",d.chgAPost[i].Avg,d.chgAPost[i].Stdev)
+	if (d.opts.Robust){
+		levelStr=fmt.Sprintf("Median:%#.2f, MAD:%#.2f",d.chgAPost[i].Median,d.chgAPost[i].MAD)
+	}
+
+	fmt.Printf("%sChg:%04d  ,  %s  len=%04d  ,  %s  ,  Chg. Conf %5.1f%% @: %d\n",
 			indentStr,i,
-                        lineStr, G_chgAPost[i].ChgEndLine-G_chgAPost[i].ChgStartLine+1,
-                        G_chgAPost[i].Avg,G_chgAPost[i].Stdev,G_chgAPost[i].Conf,G_chgAPost[i].ChgStartLine)
+                        lineStr, d.chgAPost[i].ChgEndIndex-d.chgAPost[i].ChgStartIndex+1,
+                        levelStr,d.chgAPost[i].Conf,d.chgAPost[i].ChgStartLine)
 }
 
-func PrintChg(){
+func (d *Detector) _printColumn(){
+
+	//-----------------------------------------------------------------------------------
+	//  Prints the name of the analyzed column, if it is known, and the seed
+	//	Input:   
+	//	Output:  column heading
+	//-----------------------------------------------------------------------------------
+
+	if (d.column != ""){
+		fmt.Printf("Column: %s\n",d.column)
+	}
+	fmt.Printf("Seed: %d\n",d.seed)
+}
+
+func (d *Detector) _printAnomalies(){
+
+	//-----------------------------------------------------------------------------------
+	//  Prints the spikes found by the pre-pass, if it ran
+	//	Input:   
+	//	Output:  one line per anomaly
+	//-----------------------------------------------------------------------------------
+
+	if (d.opts.Spikes == SPIKE_KEEP){
+		return
+	}
+
+        fmt.Printf("Anomalies Found: %v\n",len(d.anomalies))
+	for i, a := range d.anomalies{
+		fmt.Printf("     Spk:%04d  ,  Line Num: %04d -> %04d  ,  Time: %v -> %v  len=%d  ,  Peak:%#.2f, Score:%.1f\n",
+			i,a.StartLine,a.EndLine,a.StartTime,a.EndTime,a.EndIndex-a.StartIndex+1,a.Peak,a.Score)
+	}
+}
+
+func (d *Detector) PrintChg(){
 
 	//-----------------------------------------------------------------------------------
 	//  Prints all changes
@@ -731,15 +1228,17 @@ func PrintChg(){
 	//-----------------------------------------------------------------------------------
 
         fmt.Println()
-        fmt.Printf("Changes Found: %v\n",len(G_chgA))
-        for i := 0; i < (len(G_chgAPost)); i++ {
-		_printChg(i,false)
+	d._printColumn()
+        fmt.Printf("Changes Found: %v\n",len(d.chgA))
+        for i := 0; i < (len(d.chgAPost)); i++ {
+		d._printChg(i,false)
         }
+	d._printAnomalies()
 
         fmt.Println()
 }
 
-func PrintDebug(){
+func (d *Detector) PrintDebug(){
 
 	//-----------------------------------------------------------------------------------
 	//  Print all changes along with debug info concerning the changes
@@ -748,14 +1247,18 @@ func PrintDebug(){
 	//-----------------------------------------------------------------------------------
 
         fmt.Println()
-        fmt.Printf("Changes Found: %v\n",len(G_chgA))
-        for i := 0; i < (len(G_chgA)); i++ {
-
-                fmt.Printf("Line Num: %10d -> %-10d  len=%-5d    [ Time: %v , Value: %10.3f ] -> [ Time: %v , Value: %10.3f ]  ,  Avg:%#.2f Stdev:%#.2f    %5.1f%% CONF. @: %d  Merge=%v\n",
-			G_chgA[i].ChgStartLine, G_chgA[i].ChgEndLine, G_chgA[i].ChgEndLine-G_chgA[i].ChgStartLine+1,
-                        G_chgA[i].ChgStartTime,G_chgA[i].ChgStartValue,G_chgA[i].ChgEndTime,G_chgA[i].ChgEndValue, 
-                        G_chgA[i].Avg,G_chgA[i].Stdev,G_chgA[i].Conf,G_chgA[i].ChgStartLine,
-			G_chgA[i].Subtle)
+	d._printColumn()
+        fmt.Printf("Changes Found: %v\n",len(d.chgA))
+        for i := 0; i < (len(d.chgA)); i++ {
+
+                fmt.Printf("Line Num: %10d -> %-10d  len=%-5d    [ Time: %v , Value: %10.3f ] -> [ Time: %v , Value: %10.3f ]  ,  Avg:%#.2f Stdev:%#.2f    %5.1f%% CONF. @: %d  Merge=%v Gap=%v\n",
+			d.chgA[i].ChgStartLine, d.chgA[i].ChgEndLine, d.chgA[i].ChgEndIndex-d.chgA[i].ChgStartIndex+1,
+                        d.chgA[i].ChgStartTime,d.chgA[i].ChgStartValue,d.chgA[i].ChgEndTime,d.chgA[i].ChgEndValue, 
+                        d.chgA[i].Avg,d.chgA[i].Stdev,d.chgA[i].Conf,d.chgA[i].ChgStartLine,
+			d.chgA[i].Subtle,d.chgA[i].Gap)
+                fmt.Printf("          p=%.4f  Shift:%+.2f (%+.1f%%)  d=%+.2f  Var x%.2f  Avg CI:[%.2f , %.2f]  Median:%.2f MAD:%.2f  Slope:%+.4g (%+.1f deg)  Iter=%d  Changed=%d\n",
+			d.chgA[i].PValue,d.chgA[i].Shift,d.chgA[i].ShiftPct,d.chgA[i].EffectSize,d.chgA[i].VarRatio,
+			d.chgA[i].AvgLow,d.chgA[i].AvgHigh,d.chgA[i].Median,d.chgA[i].MAD,d.chgA[i].Slope,d.chgA[i].Angle,d.chgA[i].Iterations,d.chgA[i].Changed)
 
         }
 
@@ -763,6 +1266,23 @@ func PrintDebug(){
 
 }
 
+func NewDetector()(*Detector){
+
+	//-----------------------------------------------------------------------------------
+	//  Creates a detector initialized with the default configuration
+	//	Input:   
+	//	Output:  new detector
+	//-----------------------------------------------------------------------------------
+
+	d:=new(Detector)
+
+	d.load=LoadOptions{}.withDefaults()
+	d.opts=Options{}.withDefaults()
+	d.matchStrList = make(map[string]struct{})
+
+	return d
+}
+
 func init(){
 
 	//-----------------------------------------------------------------------------------
@@ -771,12 +1291,5 @@ func init(){
 	//	Output:  
 	//-----------------------------------------------------------------------------------
 
-	G_delim=','
-	G_timeCol=NO_TIME_COL
-	G_dataCol=DEF_DATA_COL
-	G_minConf=DEF_MIN_CONF
-	G_bootstrap=DEF_BOOTSTRAP
-	G_chgTolerance=DEF_CHG_TOLERANCE
-	G_matchStrList = make(map[string]struct{})
+	G_detector=NewDetector()
 }
-
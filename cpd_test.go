@@ -0,0 +1,465 @@
+package cpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func cusumArray(avg float64, data []float64)(float64, int64){
+
+	//-----------------------------------------------------------------------------------
+	//  The cusum as computed before it became allocation free: the whole
+	//  cumulative array is built, then scanned.  Kept as the reference
+	//	Input:  avg, data to analyze
+	//	Output: max/min delta, index of max or min
+	//-----------------------------------------------------------------------------------
+
+	var maxC,minC,peak float64
+	var peakIndex int64
+
+	cusumA:=[]float64{0.0}
+	minC=(float64)(MaxInt)
+	maxC=(float64)(MinInt)
+
+	for dataIndex := range data{
+		if (math.IsNaN(data[dataIndex])){
+			cusumA=append(cusumA,cusumA[dataIndex])
+			continue
+		}
+		cusumA=append(cusumA,cusumA[dataIndex]+(data[dataIndex]-avg))
+		if (cusumA[dataIndex+1] > maxC){
+			maxC=cusumA[dataIndex+1]
+		}
+		if (cusumA[dataIndex+1] < minC){
+			minC=cusumA[dataIndex+1]
+		}
+
+		if (cusumA[dataIndex+1] > 0){
+			if (cusumA[dataIndex+1] > peak){
+				peak=cusumA[dataIndex+1]
+				peakIndex=int64(dataIndex)
+			}
+		}else{
+			if ((cusumA[dataIndex+1]*(-1)) > peak){
+				peak=cusumA[dataIndex+1]*(-1)
+				peakIndex=int64(dataIndex)
+			}
+		}
+	}
+
+	return maxC-minC,peakIndex
+}
+
+func testSeries(rng *rand.Rand, n int)([]float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Noisy series with a level shift, negative values and some missing samples
+	//	Input:   random source, length
+	//	Output:  data
+	//-----------------------------------------------------------------------------------
+
+	data:=make([]float64,n)
+	shift:=rng.Intn(n+1)
+	for i := range data{
+		data[i]=rng.NormFloat64()*rng.ExpFloat64()-1
+		if (i >= shift){
+			data[i]=data[i]+3
+		}
+		if (rng.Intn(20) == 0){
+			data[i]=math.NaN()
+		}
+	}
+
+	return data
+}
+
+func shiftSeries(seed int64, n, at int, shift float64)([]float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Standard normal noise with a level shift
+	//	Input:   seed, length, first shifted sample, shift
+	//	Output:  data
+	//-----------------------------------------------------------------------------------
+
+	rng:=rand.New(rand.NewSource(seed))
+	data:=make([]float64,n)
+	for i := range data{
+		data[i]=rng.NormFloat64()
+		if (i >= at){
+			data[i]=data[i]+shift
+		}
+	}
+
+	return data
+}
+
+func TestCalcCusumMatchesArray(t *testing.T){
+
+	rng:=rand.New(rand.NewSource(1))
+	for iter := 0; iter < 2000; iter++ {
+		data:=testSeries(rng,rng.Intn(300))
+		avg:=calcAvg(data)
+		if (iter % 10 == 0){
+			avg=rng.NormFloat64()
+		}
+
+		//without values the old range is meaningless (MinInt-MaxInt)
+		if (!hasValues(data)){
+			continue
+		}
+
+		want, wantIndex := cusumArray(avg,data)
+		got, gotIndex := calcCusumIn(avg,data,0,int64(len(data))-1)
+		if (math.Float64bits(got) != math.Float64bits(want)) || (gotIndex != wantIndex){
+			t.Fatalf("len %d avg %v: got %v at %d, want %v at %d",
+				len(data),avg,got,gotIndex,want,wantIndex)
+		}
+	}
+}
+
+func TestCalcCusumInWindow(t *testing.T){
+
+	rng:=rand.New(rand.NewSource(2))
+	for iter := 0; iter < 2000; iter++ {
+		data:=testSeries(rng,1+rng.Intn(300))
+		avg:=calcAvg(data)
+		n:=int64(len(data))
+
+		//the peak stays in the window
+		lo:=rng.Int63n(n)
+		hi:=lo+rng.Int63n(n-lo)
+		_, gotIndex := calcCusumIn(avg,data,lo,hi)
+		if (gotIndex < lo) || (gotIndex > hi){
+			t.Fatalf("len %d window %d..%d: peak at %d",n,lo,hi,gotIndex)
+		}
+	}
+}
+
+func TestSplitRange(t *testing.T){
+
+	d:=NewDetector()
+	d.SetMinSegment(5,0)
+	lo, hi := d.splitRange(10,29)
+	if (lo != 4) || (hi != 14){
+		t.Fatalf("got %d..%d, want 4..14",lo,hi)
+	}
+
+	lo, hi = d.splitRange(10,18)
+	if (lo <= hi){
+		t.Fatalf("segment of 9 split at %d..%d",lo,hi)
+	}
+}
+
+func TestLimitSetters(t *testing.T){
+
+	d:=NewDetector()
+	if err:=d.SetMaxChanges(3); err != nil{
+		t.Fatal(err)
+	}
+	if err:=d.SetMaxChanges(-1); !errors.Is(err,ErrBadOption){
+		t.Fatalf("SetMaxChanges(-1): %v",err)
+	}
+	if err:=d.SetMinSegment(-2,0); !errors.Is(err,ErrBadOption){
+		t.Fatalf("SetMinSegment(-2): %v",err)
+	}
+	if err:=d.SetMaxDepth(-1); !errors.Is(err,ErrBadOption){
+		t.Fatalf("SetMaxDepth(-1): %v",err)
+	}
+	if (d.opts.MaxChanges != 3) || (d.opts.MinLen != DEF_MIN_INTERVAL) || (d.opts.MaxDepth != 0){
+		t.Fatalf("rejected limits were applied: %+v",d.opts)
+	}
+}
+
+func TestSetMinConf(t *testing.T){
+
+	d:=NewDetector()
+	if err:=d.SetMinConf(0); (err != nil) || (d.opts.MinConf != DEF_MIN_CONF){
+		t.Fatalf("SetMinConf(0): %v, conf %v",err,d.opts.MinConf)
+	}
+	for _, conf := range []float64{-1,101,math.NaN()}{
+		if err:=d.SetMinConf(conf); !errors.Is(err,ErrBadOption){
+			t.Fatalf("SetMinConf(%v): %v",conf,err)
+		}
+	}
+	if (d.opts.MinConf != DEF_MIN_CONF){
+		t.Fatalf("rejected confidence was applied: %v",d.opts.MinConf)
+	}
+}
+
+func TestParseEpoch(t *testing.T){
+
+	cases:=[]struct {
+		text, layout string
+		want         time.Time
+	}{
+		{"1700000000123", TIME_UNIX_MS, time.UnixMilli(1700000000123)},
+		{"1700000000.123456789", TIME_UNIX, time.Unix(1700000000,123456789)},
+		{"1700000000.5", TIME_UNIX, time.Unix(1700000000,500000000)},
+		{"-1.25", TIME_UNIX, time.Unix(-2,750000000)},
+		{"1700000000123.5", TIME_UNIX_MS, time.UnixMilli(1700000000123).Add(500*time.Microsecond)},
+		{"1.7e9", TIME_UNIX, time.Unix(1700000000,0)},
+	}
+	for _, c := range cases{
+		got, err := parseTime(c.text,c.layout,nil)
+		if (err != nil) || (!got.Equal(c.want)){
+			t.Fatalf("%s %q: got %v %v, want %v",c.layout,c.text,got,err,c.want)
+		}
+	}
+	if got := formatTime(time.UnixMilli(1700000000123),TIME_UNIX_MS); got != "1700000000123"{
+		t.Fatalf("formatTime: %s",got)
+	}
+	for _, text := range []string{"","-",".","1.2.3","12a","1.x"}{
+		if _, err := parseTime(text,TIME_UNIX,nil); !errors.Is(err,ErrBadTime){
+			t.Fatalf("%q parsed: %v",text,err)
+		}
+	}
+}
+
+func TestMeanVarFlags(t *testing.T){
+
+	cases:=[]struct {
+		shift, sd float64
+		want      int
+	}{
+		{2, 3, CHG_MEAN|CHG_VAR},
+		{1, 1, CHG_MEAN},
+		{0, 3, CHG_VAR},
+	}
+	rng:=rand.New(rand.NewSource(3))
+	for _, c := range cases{
+		data:=make([]float64,400)
+		for i := range data{
+			data[i]=rng.NormFloat64()
+			if (i >= 200){
+				data[i]=c.shift+c.sd*data[i]
+			}
+		}
+		res, err := Analyze(context.Background(),data,nil,Options{Seed: 1, Bootstrap: 1000, Target: TARGET_MEANVAR})
+		if (err != nil){
+			t.Fatal(err)
+		}
+		if (len(res.Changes) != 2) || (res.Changes[1].Changed != c.want){
+			t.Fatalf("shift %v sd %v: changes %+v, want one flagged %d",c.shift,c.sd,res.Changes,c.want)
+		}
+	}
+}
+
+func TestPELTCosts(t *testing.T){
+
+	rng:=rand.New(rand.NewSource(3))
+	countRng:=rand.New(rand.NewSource(4))
+	levels:=make([]float64,600)
+	counts:=make([]float64,600)
+	for i := range levels{
+		mean, rate := 0.0, 2.0
+		if (i >= 200){
+			mean, rate = 3, 8
+		}
+		if (i >= 400){
+			mean, rate = -1, 4
+		}
+		levels[i]=mean+rng.NormFloat64()
+
+		//poisson counts by inversion
+		p, k, u := math.Exp(-rate), 0.0, countRng.Float64()
+		for cum := p; u > cum; cum=cum+p {
+			k++
+			p=p*rate/k
+		}
+		counts[i]=k
+	}
+
+	cases:=[]struct {
+		cost int
+		data []float64
+		want []int64
+	}{
+		{COST_NORMAL_MEAN, levels, []int64{200, 400}},
+		{COST_NORMAL_MEANVAR, levels, []int64{200, 400}},
+		{COST_NONPARAM, levels, []int64{200, 400}},
+		{COST_POISSON, counts, []int64{200, 400}},
+	}
+	for _, c := range cases{
+		got, _, err := pelt(context.Background(),c.data,Options{Cost: c.cost, MinLen: DEF_MIN_INTERVAL})
+		if (err != nil){
+			t.Fatal(err)
+		}
+		if (fmt.Sprint(got) != fmt.Sprint(c.want)){
+			t.Errorf("cost %d: changes at %v, want %v",c.cost,got,c.want)
+		}
+	}
+}
+
+func TestBayesShift(t *testing.T){
+
+	b, err := NewBayes(BayesOptions{})
+	if (err != nil){
+		t.Fatal(err)
+	}
+	var state BayesT
+	for i, x := range shiftSeries(21,110,100,4){
+		state, err = b.Update(x)
+		if (err != nil){
+			t.Fatal(err)
+		}
+		if (i == 99) && (state.MAPRun != 100){
+			t.Fatalf("before the shift: run length %d, want 100",state.MAPRun)
+		}
+		if (i == 101) && ((state.MAPRun != 2) || (state.ChgStart != 100)){
+			t.Fatalf("after the shift: run length %d from %d, want 2 from 100",state.MAPRun,state.ChgStart)
+		}
+	}
+
+	//a missing sample ages every run without evidence
+	prev:=state
+	hazard:=1/float64(DEF_RUN_LENGTH)
+	state, err = b.Update(math.NaN())
+	if (err != nil) || (state.Index != prev.Index+1) || (state.MAPRun != prev.MAPRun+1){
+		t.Fatalf("missing sample: %v, index %d run %d after index %d run %d",
+			err,state.Index,state.MAPRun,prev.Index,prev.MAPRun)
+	}
+	if (math.Abs(state.RunProbs[0]-hazard) > 1e-9){
+		t.Fatalf("missing sample: change probability %v, want the hazard %v",state.RunProbs[0],hazard)
+	}
+	for r := 1; r < len(state.RunProbs); r++ {
+		if (math.Abs(state.RunProbs[r]-prev.RunProbs[r-1]*(1-hazard)) > 1e-9){
+			t.Fatalf("missing sample: run %d probability %v, was %v",r,state.RunProbs[r],prev.RunProbs[r-1])
+		}
+	}
+
+	//after a reset it matches a new detector
+	b.Reset()
+	fresh, _ := NewBayes(BayesOptions{})
+	got, _ := b.Update(1)
+	want, _ := fresh.Update(1)
+	if (b.Index() != 1) || (fmt.Sprint(got) != fmt.Sprint(want)){
+		t.Fatalf("after reset: %+v, want %+v",got,want)
+	}
+
+	if _, err := b.Update(math.Inf(1)); !errors.Is(err,ErrBadValue){
+		t.Fatalf("Update(+Inf): %v",err)
+	}
+	counts, _ := NewBayes(BayesOptions{Model: MODEL_POISSON})
+	if _, err := counts.Update(-1); !errors.Is(err,ErrNegativeCount){
+		t.Fatalf("Poisson Update(-1): %v",err)
+	}
+	for _, opts := range []BayesOptions{{Model: 5},{RunLength: 0.5},{MaxRun: -1},{Beta: -1}}{
+		if _, err := NewBayes(opts); !errors.Is(err,ErrBadOption){
+			t.Fatalf("NewBayes(%+v): %v",opts,err)
+		}
+	}
+}
+
+func TestChartShift(t *testing.T){
+
+	data:=shiftSeries(21,100,60,1.5)
+
+	c, err := NewChart(ChartOptions{})
+	if (err != nil){
+		t.Fatal(err)
+	}
+	for i, x := range data{
+		state, err := c.Add(x)
+		if (err != nil){
+			t.Fatal(err)
+		}
+		if (state.Calibrating != (i < DEF_CALIBRATION)) || ((i < DEF_CALIBRATION-1) && (!math.IsNaN(c.Target()))){
+			t.Fatalf("sample %d: calibrating %v, target %v",i,state.Calibrating,c.Target())
+		}
+		if (i == DEF_CALIBRATION-1){
+			window:=data[:DEF_CALIBRATION]
+			if (c.Target() != calcAvg(window)) || (c.Sigma() != calcStdev(window,calcAvg(window))){
+				t.Fatalf("calibrated to %v, %v",c.Target(),c.Sigma())
+			}
+		}
+		if (state.Signal){
+			if (i != 65) || (state.Direction != 1) || (state.ChgStart != 58){
+				t.Fatalf("first signal at %d, direction %d from %d, want 65, 1 from 58",i,state.Direction,state.ChgStart)
+			}
+			break
+		}
+	}
+
+	//a missing sample leaves the sums alone
+	prev, _ := c.Add(data[66])
+	state, err := c.Add(math.NaN())
+	if (err != nil) || (state.Calibrating) || (state.CPlus != prev.CPlus) || (state.CMinus != prev.CMinus){
+		t.Fatalf("missing sample: %v, %+v after %+v",err,state,prev)
+	}
+
+	//recalibration takes the window after each signal
+	re, _ := NewChart(ChartOptions{Recalibrate: true})
+	for i, x := range data{
+		state, _ := re.Add(x)
+		if (state.Calibrating != ((i < DEF_CALIBRATION) || ((i > 65) && (i <= 65+DEF_CALIBRATION)))){
+			t.Fatalf("recalibrating, sample %d: calibrating %v",i,state.Calibrating)
+		}
+		if (i == 65+DEF_CALIBRATION) && (re.Target() != calcAvg(data[66:66+DEF_CALIBRATION])){
+			t.Fatalf("recalibrated to %v",re.Target())
+		}
+	}
+
+	//a reset calibrates again
+	c.Reset()
+	state, _ = c.Add(0)
+	if (state.Index != 0) || (!state.Calibrating) || (!math.IsNaN(c.Sigma())){
+		t.Fatalf("after reset: %+v, sigma %v",state,c.Sigma())
+	}
+
+	//with a given target and sigma there is no calibration
+	fixed, _ := NewChart(ChartOptions{Target: 1, Sigma: 2})
+	state, _ = fixed.Add(4)
+	if (state.Calibrating) || (state.CPlus != 2) || (fixed.Target() != 1) || (fixed.Sigma() != 2){
+		t.Fatalf("fixed chart: %+v",state)
+	}
+
+	if _, err := c.Add(math.Inf(-1)); !errors.Is(err,ErrBadValue){
+		t.Fatalf("Add(-Inf): %v",err)
+	}
+	for _, opts := range []ChartOptions{{H: -1},{Sigma: -1},{Calibrate: 1},{Sigma: 1, Recalibrate: true}}{
+		if _, err := NewChart(opts); !errors.Is(err,ErrBadOption){
+			t.Fatalf("NewChart(%+v): %v",opts,err)
+		}
+	}
+}
+
+func BenchmarkCalcCusum(b *testing.B){
+
+	data:=testSeries(rand.New(rand.NewSource(1)),1000)
+	avg:=calcAvg(data)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		calcCusumIn(avg,data,0,int64(len(data))-1)
+	}
+}
+
+func BenchmarkCusumArray(b *testing.B){
+
+	data:=testSeries(rand.New(rand.NewSource(1)),1000)
+	avg:=calcAvg(data)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cusumArray(avg,data)
+	}
+}
+
+func BenchmarkBootChunk(b *testing.B){
+
+	data:=testSeries(rand.New(rand.NewSource(1)),1000)
+	avg:=calcAvg(data)
+	delta, _ := calcCusumIn(avg,data,0,int64(len(data))-1)
+	d:=NewDetector()
+	stat:=d.cusumStat(avg,0,int64(len(data))-1)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		bootChunk(int64(i),data,stat,delta,BOOT_CHUNK,BOOT_SHUFFLE,1)
+	}
+}
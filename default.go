@@ -0,0 +1,211 @@
+package cpd
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Package level API.  Each function operates on the default detector,
+// G_detector, so callers written before Detector existed keep working.
+//
+// The state globals are gone: G_chgA, G_chgAPost, G_matchA, G_rawData,
+// G_timeData, G_timeCol, G_dataCol, G_delim, G_minConf, G_bootstrap,
+// G_chgTolerance and G_matchStrList.  Read results through GetAllChanges
+// (G_chgA), GetSegments (G_chgAPost), GetChgDataVal and GetChgTimeVal, and
+// set options through the Set functions.
+
+func SetBootstrapLimit(bootstrap int64){
+	G_detector.SetBootstrapLimit(bootstrap)
+}
+
+func SetTimeCol(timeCol int32){
+	G_detector.SetTimeCol(timeCol)
+}
+
+func SetDataCol(dataCol int32){
+	G_detector.SetDataCol(dataCol)
+}
+
+func SetTimeNDataCols(timeCol, dataCol int32){
+	G_detector.SetTimeNDataCols(timeCol,dataCol)
+}
+
+func SetHeader(header int){
+	G_detector.SetHeader(header)
+}
+
+func SetTimeColName(name string){
+	G_detector.SetTimeColName(name)
+}
+
+func SetDataColName(name string){
+	G_detector.SetDataColName(name)
+}
+
+func SetColumnName(name string){
+	G_detector.SetColumnName(name)
+}
+
+func SetTimeLayout(layout string){
+	G_detector.SetTimeLayout(layout)
+}
+
+func SetTimeZone(loc *time.Location){
+	G_detector.SetTimeZone(loc)
+}
+
+func SetGapPolicy(maxInterval time.Duration, policy int){
+	G_detector.SetGapPolicy(maxInterval,policy)
+}
+
+func SetResample(interval time.Duration, agg int){
+	G_detector.SetResample(interval,agg)
+}
+
+func SetMissing(policy int){
+	G_detector.SetMissing(policy)
+}
+
+func SetWorkers(workers int){
+	G_detector.SetWorkers(workers)
+}
+
+func SetSeed(seed int64){
+	G_detector.SetSeed(seed)
+}
+
+func SetRandSource(src rand.Source){
+	G_detector.SetRandSource(src)
+}
+
+func GetSeed()(int64){
+	return G_detector.GetSeed()
+}
+
+func SetEarlyStop(earlyStop bool){
+	G_detector.SetEarlyStop(earlyStop)
+}
+
+func SetMinConf(conf float64)(error){
+	return G_detector.SetMinConf(conf)
+}
+
+func SetMethod(method int){
+	G_detector.SetMethod(method)
+}
+
+func SetCost(cost int){
+	G_detector.SetCost(cost)
+}
+
+func SetPenalty(penalty int, value float64){
+	G_detector.SetPenalty(penalty,value)
+}
+
+func SetTarget(target int){
+	G_detector.SetTarget(target)
+}
+
+func SetRobust(robust bool){
+	G_detector.SetRobust(robust)
+}
+
+func SetSpikes(policy, maxLen int, thresh float64){
+	G_detector.SetSpikes(policy,maxLen,thresh)
+}
+
+func SetBlockBootstrap(mode, blockLen int){
+	G_detector.SetBlockBootstrap(mode,blockLen)
+}
+
+func SetMinSegment(samples int, dur time.Duration)(error){
+	return G_detector.SetMinSegment(samples,dur)
+}
+
+func SetMaxChanges(count int)(error){
+	return G_detector.SetMaxChanges(count)
+}
+
+func SetMaxDepth(depth int)(error){
+	return G_detector.SetMaxDepth(depth)
+}
+
+func SetMerge(mode int, thresh float64){
+	G_detector.SetMerge(mode,thresh)
+}
+
+func SetChgTolerance(i int){
+	G_detector.SetChgTolerance(i)
+}
+
+func NoTimeCol(){
+	G_detector.NoTimeCol()
+}
+
+func SetDelim(delim rune){
+	G_detector.SetDelim(delim)
+}
+
+func SetStrict(strict bool){
+	G_detector.SetStrict(strict)
+}
+
+func GetDataFromFile(fname string)(error){
+	return G_detector.GetDataFromFile(fname)
+}
+
+func SetData(data []float64, labels []string)(error){
+	return G_detector.SetData(data,labels)
+}
+
+func SetStamps(stamps []time.Time)(error){
+	return G_detector.SetStamps(stamps)
+}
+
+func SetBreaks(breaks []int64){
+	G_detector.SetBreaks(breaks)
+}
+
+func SetLines(lines []int64)(error){
+	return G_detector.SetLines(lines)
+}
+
+func GetLoadReport()(LoadReport){
+	return G_detector.GetLoadReport()
+}
+
+func FindChange(){
+	G_detector.FindChange()
+}
+
+func GetAllChanges()([]ChgT){
+	return G_detector.GetAllChanges()
+}
+
+func GetSegments()([]ChgT){
+	return G_detector.GetSegments()
+}
+
+func GetChgDataVal(chgIndex int64)(DataT){
+	return G_detector.GetChgDataVal(chgIndex)
+}
+
+func GetChgTimeVal(chgIndex int64)(TimeT){
+	return G_detector.GetChgTimeVal(chgIndex)
+}
+
+func GetAnomalies()([]AnomalyT){
+	return G_detector.GetAnomalies()
+}
+
+func GetChgCount()(int){
+	return G_detector.GetChgCount()
+}
+
+func PrintChg(){
+	G_detector.PrintChg()
+}
+
+func PrintDebug(){
+	G_detector.PrintDebug()
+}
@@ -0,0 +1,242 @@
+package cpd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ///////////////////// CONSTANTS
+const GAP_REPORT   = 0 // only list the gaps in the load report
+const GAP_SPLIT    = 1 // analyze the data between gaps separately
+const GAP_RESAMPLE = 2 // aggregate the data into fixed intervals
+
+const AGG_MEAN = 0
+const AGG_LAST = 1
+const AGG_MAX  = 2
+
+
+// ///////////////////// ERRORS
+var ErrNoStamps = errors.New("cpd: gap handling needs a parsed time column")
+
+
+// ///////////////////// TYPES
+
+// GapT is a stretch of time longer than the allowed interval with no data.
+// Index is the first sample after the gap, or the first bucket after it
+// when the data is resampled.
+type GapT struct {
+	Index    int64
+	Start    time.Time // last stamp before the gap
+	End      time.Time // first stamp after the gap
+}
+
+
+func (g GapT) Duration()(time.Duration){
+	return g.End.Sub(g.Start)
+}
+
+func (d *Detector) SetBreaks(breaks []int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Splits the data at the given indexes; changes are never detected or merged
+	//  across a split
+	//	Input:   indexes of the first sample after each split, nil for none
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.breaks=append([]int64(nil), breaks...)
+	sort.Slice(d.breaks,func(i, j int) bool { return d.breaks[i] < d.breaks[j] })
+}
+
+func (d *Detector) SetGapPolicy(maxInterval time.Duration, policy int){
+
+	//-----------------------------------------------------------------------------------
+	//  Treats intervals longer than maxInterval as gaps, handled per policy:
+	//  GAP_REPORT, GAP_SPLIT or GAP_RESAMPLE.  Needs a time layout
+	//	Input:   longest normal interval (0 to ignore gaps), policy
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.load.MaxInterval=maxInterval
+	d.load.GapPolicy=policy
+}
+
+func (d *Detector) SetResample(interval time.Duration, agg int){
+
+	//-----------------------------------------------------------------------------------
+	//  Interval and aggregate (AGG_MEAN, AGG_LAST, AGG_MAX) used by GAP_RESAMPLE
+	//	Input:   bucket width, aggregate
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.load.Resample=interval
+	d.load.ResampleAgg=agg
+}
+
+func findGaps(stamps []time.Time, maxInterval time.Duration)([]GapT){
+
+	//-----------------------------------------------------------------------------------
+	//  Lists every interval between consecutive stamps longer than maxInterval
+	//	Input:   time stamps, longest normal interval
+	//	Output:  gaps
+	//-----------------------------------------------------------------------------------
+
+	var gaps []GapT
+
+	for i := 1; i < len(stamps); i++ {
+		if (stamps[i].Sub(stamps[i-1]) > maxInterval){
+			gaps=append(gaps,GapT{Index: int64(i), Start: stamps[i-1], End: stamps[i]})
+		}
+	}
+
+	return gaps
+}
+
+func formatTime(t time.Time, layout string)(string){
+
+	//-----------------------------------------------------------------------------------
+	//  Formats a time stamp in the layout it was parsed with
+	//	Input:   time, layout as for parseTime
+	//	Output:  text
+	//-----------------------------------------------------------------------------------
+
+	switch (layout){
+	case TIME_UNIX:
+		return strconv.FormatInt(t.Unix(),10)
+	case TIME_UNIX_MS:
+		return strconv.FormatInt(t.UnixMilli(),10)
+	case TIME_RFC3339:
+		return t.Format(time.RFC3339Nano)
+	}
+
+	return t.Format(layout)
+}
+
+func resample(series *Series, interval time.Duration, agg int, layout string){
+
+	//-----------------------------------------------------------------------------------
+	//  Aggregates the series into buckets of a fixed width, starting at the first
+	//  stamp.  Empty buckets are kept as missing (NaN) values so an outage keeps its
+	//  length; they take the line of the last sample before them, and each other
+	//  bucket the line of its first sample.  Gaps are re-indexed to the buckets
+	//	Input:   series with stamps, bucket width, aggregate, time layout for labels
+	//	Output:  series updated in place
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var keys []int64
+	var data DataT
+	var labels TimeT
+	var stamps []time.Time
+	var lines []int64
+	var line int64
+
+	if (len(series.Stamps) == 0){
+		return
+	}
+
+	t0:=series.Stamps[0]
+	bucketOf:=func(stamp time.Time)(int64){
+		return int64(math.Floor(float64(stamp.Sub(t0))/float64(interval)))
+	}
+	buckets:=make(map[int64][]float64)
+	firstLine:=make(map[int64]int64)
+
+	for i, stamp := range series.Stamps{
+		key:=bucketOf(stamp)
+		if _, ok := buckets[key]; !ok{
+			keys=append(keys,key)
+			if (i < len(series.Lines)){
+				firstLine[key]=series.Lines[i]
+			}
+		}
+		buckets[key]=append(buckets[key],series.Data[i])
+	}
+	sort.Slice(keys,func(i, j int) bool { return keys[i] < keys[j] })
+
+	first:=keys[0]
+	for key := first; key <= keys[len(keys)-1]; key++ {
+		values, ok := buckets[key]
+		stamp:=t0.Add(time.Duration(key)*interval)
+		if (ok){
+			line=firstLine[key]
+		}
+
+		//missing (NaN) values only count if the bucket has nothing else
+		switch {
+		case len(values) == 0:
+			data=append(data,math.NaN())
+		case agg == AGG_LAST:
+			last:=math.NaN()
+			for _, value := range values{
+				if (!math.IsNaN(value)){
+					last=value
+				}
+			}
+			data=append(data,last)
+		case agg == AGG_MAX:
+			max:=math.NaN()
+			for _, value := range values{
+				if (math.IsNaN(max)) || (value > max){
+					max=value
+				}
+			}
+			data=append(data,max)
+		default:
+			data=append(data,calcAvg(values))
+		}
+		stamps=append(stamps,stamp)
+		labels=append(labels,formatTime(stamp,layout))
+		lines=append(lines,line)
+	}
+
+	//gaps start at the bucket holding their first stamp after the outage
+	for i := range series.Report.Gaps{
+		series.Report.Gaps[i].Index=bucketOf(series.Report.Gaps[i].End)-first
+	}
+
+	series.Data=data
+	series.Time=labels
+	series.Stamps=stamps
+	if (len(series.Lines) > 0){
+		series.Lines=lines
+	}
+}
+
+func applyGaps(series *Series, opts LoadOptions)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Finds the gaps in a loaded series and applies the gap policy
+	//	Input:   series, layout with the gap settings
+	//	Output:  series updated in place, error if the settings cannot be applied
+	//-----------------------------------------------------------------------------------
+
+	if (opts.MaxInterval <= 0) && (opts.GapPolicy != GAP_RESAMPLE){
+		return nil
+	}
+	if (opts.TimeLayout == "") || (opts.TimeCol == NO_TIME_COL){
+		return ErrNoStamps
+	}
+
+	if (opts.MaxInterval > 0){
+		series.Report.Gaps=findGaps(series.Stamps,opts.MaxInterval)
+	}
+
+	switch (opts.GapPolicy){
+	case GAP_SPLIT:
+		for _, gap := range series.Report.Gaps{
+			series.Breaks=append(series.Breaks,gap.Index)
+		}
+	case GAP_RESAMPLE:
+		if (opts.Resample <= 0){
+			return fmt.Errorf("%w: resample interval %v", ErrBadOption, opts.Resample)
+		}
+		resample(series,opts.Resample,opts.ResampleAgg,opts.TimeLayout)
+	}
+
+	return nil
+}
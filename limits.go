@@ -0,0 +1,208 @@
+package cpd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+
+func (d *Detector) SetMinSegment(samples int, dur time.Duration)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Sets the shortest segment reported, in samples and, for data with time
+	//  stamps, in time from its first to its last sample
+	//	Input:   samples (0 for DEF_MIN_INTERVAL), duration (0 for no limit)
+	//	Output:  error if either is negative, the limits are then unchanged
+	//-----------------------------------------------------------------------------------
+
+	if (samples == 0){
+		samples=DEF_MIN_INTERVAL
+	}
+
+	opts:=d.opts
+	opts.MinLen=samples
+	opts.MinDuration=dur
+	if err:=opts.validateLimits(); err != nil{
+		return err
+	}
+
+	d.opts=opts
+	return nil
+}
+
+func (d *Detector) SetMaxChanges(count int)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Caps the number of changes reported, the most confident (for PELT, those
+	//  saving the most cost) are kept.  Changes forced by gaps are not counted
+	//	Input:   change count, 0 for no limit
+	//	Output:  error if the count is negative, the cap is then unchanged
+	//-----------------------------------------------------------------------------------
+
+	opts:=d.opts
+	opts.MaxChanges=count
+	if err:=opts.validateLimits(); err != nil{
+		return err
+	}
+
+	d.opts=opts
+	return nil
+}
+
+func (d *Detector) SetMaxDepth(depth int)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Caps the recursion of the binary segmentation: depth 1 splits the data once
+	//	Input:   depth, 0 for no limit
+	//	Output:  error if the depth is negative, the cap is then unchanged
+	//-----------------------------------------------------------------------------------
+
+	opts:=d.opts
+	opts.MaxDepth=depth
+	if err:=opts.validateLimits(); err != nil{
+		return err
+	}
+
+	d.opts=opts
+	return nil
+}
+
+func (opts Options) validateLimits()(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Checks the segment and change limits
+	//	Input:
+	//	Output:  error wrapping ErrBadOption
+	//-----------------------------------------------------------------------------------
+
+	if (opts.MinLen < 0){
+		return fmt.Errorf("%w: min segment length %d", ErrBadOption, opts.MinLen)
+	}
+	if (opts.MinDuration < 0){
+		return fmt.Errorf("%w: min segment duration %v", ErrBadOption, opts.MinDuration)
+	}
+	if (opts.MaxChanges < 0){
+		return fmt.Errorf("%w: max changes %d", ErrBadOption, opts.MaxChanges)
+	}
+	if (opts.MaxDepth < 0){
+		return fmt.Errorf("%w: max depth %d", ErrBadOption, opts.MaxDepth)
+	}
+
+	return nil
+}
+
+func (d *Detector) segmentOK(first, last int64)(bool){
+
+	//-----------------------------------------------------------------------------------
+	//  Checks a segment against the minimum length and duration
+	//	Input:   first and last sample index
+	//	Output:  true if the segment is long enough
+	//-----------------------------------------------------------------------------------
+
+	if (last-first+1 < int64(d.opts.MinLen)){
+		return false
+	}
+	if (d.opts.MinDuration > 0){
+		start:=d.stampAt(first)
+		end:=d.stampAt(last)
+		if (!start.IsZero()) && (!end.IsZero()) && (end.Sub(start) < d.opts.MinDuration){
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *Detector) splitRange(base_start, base_end int64)(int64, int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Positions where a segment can be split with both parts long enough.  The
+	//  length and duration of a part only grow as the split moves away from it
+	//	Input:   first and last sample index of the segment
+	//	Output:  first and last allowed index, relative to the segment, of the last
+	//		 sample before a change; first > last if the segment cannot be split
+	//-----------------------------------------------------------------------------------
+
+	n:=int(base_end-base_start+1)
+	lo:=sort.Search(n,func(p int) bool { return d.segmentOK(base_start,base_start+int64(p)) })
+	hi:=sort.Search(n,func(p int) bool { return !d.segmentOK(base_start+int64(p)+1,base_end) })-1
+
+	return int64(lo), int64(hi)
+}
+
+func (d *Detector) limitChanges(){
+
+	//-----------------------------------------------------------------------------------
+	//  Drops the least confident changes until every segment is long enough and no
+	//  more than MaxChanges are left; changes of equal confidence, such as every
+	//  PELT change, go by their Strength.  The start, the dummy end and gaps are
+	//  kept
+	//	Input:   sorted change struct, with the dummy end
+	//	Output:  change struct updated
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var order []int
+	var count int
+
+	if (d.opts.MinLen <= 1) && (d.opts.MinDuration == 0) && (d.opts.MaxChanges == 0){
+		return
+	}
+
+	last:=len(d.chgA)-1
+	kept:=make([]bool,len(d.chgA))
+	for i := range d.chgA{
+		kept[i]=true
+		if (i > 0) && (i < last) && (!d.chgA[i].Gap){
+			order=append(order,i)
+		}
+	}
+	sort.SliceStable(order,func(i, j int) bool {
+		a, b := &d.chgA[order[i]], &d.chgA[order[j]]
+		if (a.Conf != b.Conf){
+			return a.Conf < b.Conf
+		}
+		return a.Strength < b.Strength
+	})
+
+	//segments too short, judged against the changes kept so far
+	for _, i := range order{
+		prev:=i-1
+		for !kept[prev] {
+			prev--
+		}
+		next:=i+1
+		for !kept[next] {
+			next++
+		}
+		if (!d.segmentOK(d.chgA[prev].Index,d.chgA[i].Index-1)) ||
+		   (!d.segmentOK(d.chgA[i].Index,d.chgA[next].Index-1)){
+			kept[i]=false
+		}
+	}
+
+	//too many changes
+	for _, i := range order{
+		if (kept[i]){
+			count++
+		}
+	}
+	for _, i := range order{
+		if (d.opts.MaxChanges == 0) || (count <= d.opts.MaxChanges){
+			break
+		}
+		if (kept[i]){
+			kept[i]=false
+			count--
+		}
+	}
+
+	chgA:=d.chgA[:0]
+	for i, chg := range d.chgA{
+		if (kept[i]){
+			chgA=append(chgA,chg)
+		}
+	}
+	d.chgA=chgA
+}
@@ -0,0 +1,738 @@
+package cpd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ///////////////////// CONSTANTS
+const HEADER_AUTO    = 0 // header if the first row's data cells all hold text
+const HEADER_NONE    = 1
+const HEADER_PRESENT = 2
+
+
+// ///////////////////// ERRORS
+var ErrMissingColumn = errors.New("cpd: row has no such column")
+var ErrUnknownColumn = errors.New("cpd: no column with this name")
+var ErrNoHeader = errors.New("cpd: columns selected by name but there is no header")
+
+
+// ///////////////////// TYPES
+
+// LoadError describes a row of the data file that could not be loaded.  Line
+// is the line in the file, Column the 1-based field within the row.
+type LoadError struct {
+	File   string
+	Line   int64
+	Column int32
+	Text   string
+	Err    error
+}
+
+// LoadReport summarizes a load: rows read, rows accepted and the rows that
+// were skipped (lenient mode only).
+type LoadReport struct {
+	Rows     int64
+	Accepted int64
+	Skipped  []LoadError
+	Missing  []LoadError // missing values kept under MISSING_FILL/INTERP/NAN
+	Gaps     []GapT // intervals longer than MaxInterval, indexed after resampling
+}
+
+// LoadOptions describes the layout of delimited data.  Columns are 1-based;
+// zero valued fields take the defaults (comma delimited, data in column 1,
+// no time column, header detected).  A column name, matched against the
+// header ignoring case, takes precedence over the column number.
+type LoadOptions struct {
+	Delim       rune
+	TimeCol     int32
+	DataCol     int32
+	TimeColName string
+	DataColName string
+	Header      int
+	Strict      bool
+	Missing     int // MISSING_DROP, MISSING_FILL, MISSING_INTERP or MISSING_NAN
+
+	// time column parsing, see SetTimeLayout
+	TimeLayout  string
+	TimeZone    *time.Location
+
+	// gap handling, needs TimeLayout; see SetGapPolicy and SetResample
+	MaxInterval time.Duration
+	GapPolicy   int
+	Resample    time.Duration
+	ResampleAgg int
+
+	// multi column loads (LoadCSVColumns, GetColumnsFromFile)
+	AllCols      bool
+	DataCols     []int32
+	DataColNames []string
+}
+
+// Series is a loaded data column with one label per sample.  Name and
+// TimeName are the column headings, when the data has a header, and Col
+// the 1-based column the data came from.
+type Series struct {
+	Name     string
+	Col      int32
+	TimeCol  int32 // 1-based time column, NO_TIME_COL if Time numbers the samples
+	TimeName string
+	Data     DataT
+	Time     TimeT
+	Stamps   []time.Time // parsed Time, when a TimeLayout is given
+	Lines    []int64     // source line of each sample
+	Breaks   []int64     // first sample after each gap (GAP_SPLIT)
+	Report   LoadReport
+}
+
+
+func (e *LoadError) Error()(string){
+
+	//-----------------------------------------------------------------------------------
+	//  Formats the error as file:line:column: reason "text"
+	//	Input:
+	//	Output:  error string
+	//-----------------------------------------------------------------------------------
+
+	var sb strings.Builder
+
+	sb.WriteString("cpd: ")
+	if (e.File != ""){
+		sb.WriteString(e.File+":")
+	}
+	fmt.Fprintf(&sb,"%d",e.Line)
+	if (e.Column > 0){
+		fmt.Fprintf(&sb,":%d",e.Column)
+	}
+	fmt.Fprintf(&sb,": %s",strings.TrimPrefix(e.Err.Error(),"cpd: "))
+	if (e.Text != ""){
+		fmt.Fprintf(&sb," %q",e.Text)
+	}
+
+	return sb.String()
+}
+
+func (e *LoadError) Unwrap()(error){
+	return e.Err
+}
+
+func (d *Detector) SetStrict(strict bool){
+
+	//-----------------------------------------------------------------------------------
+	//  Strict loading fails on the first bad row.  Otherwise bad rows are skipped and
+	//  recorded in the load report
+	//	Input:   true for strict loading
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.load.Strict=strict
+}
+
+func (d *Detector) GetLoadReport()(LoadReport){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the report of the last load
+	//	Input:
+	//	Output:  rows read, accepted and skipped
+	//-----------------------------------------------------------------------------------
+
+	return d.report
+}
+
+func (opts LoadOptions) withDefaults()(LoadOptions){
+
+	//-----------------------------------------------------------------------------------
+	//  Fills in the defaults for all zero valued load options
+	//	Input:
+	//	Output:  completed options
+	//-----------------------------------------------------------------------------------
+
+	if (opts.Delim == 0){
+		opts.Delim=','
+	}
+	if (opts.DataCol <= 0){
+		opts.DataCol=DEF_DATA_COL
+	}
+	if (opts.TimeCol < 0){
+		opts.TimeCol=NO_TIME_COL
+	}
+
+	return opts
+}
+
+func (opts *LoadOptions) multiColumn()(bool){
+
+	//-----------------------------------------------------------------------------------
+	//  True if more than the single DataCol/DataColName column is to be loaded
+	//	Input:
+	//	Output:  true for multi column loads
+	//-----------------------------------------------------------------------------------
+
+	return (opts.AllCols) || (len(opts.DataCols) > 0) || (len(opts.DataColNames) > 0)
+}
+
+func (opts *LoadOptions) selectColumns(record []string)([]int32){
+
+	//-----------------------------------------------------------------------------------
+	//  Lists the data columns to load, by number.  Columns named in the options are
+	//  resolved by readHeader
+	//	Input:   first row
+	//	Output:  1-based data column numbers
+	//-----------------------------------------------------------------------------------
+
+	var cols []int32
+
+	switch {
+	case opts.AllCols:
+		//every column except time, text columns are dropped by readCSV
+		for col := int32(1); col <= int32(len(record)); col++ {
+			if (col != opts.TimeCol){
+				cols=append(cols,col)
+			}
+		}
+	case len(opts.DataCols) > 0:
+		cols=append(cols,opts.DataCols...)
+	default:
+		cols=append(cols,opts.DataCol)
+	}
+
+	return cols
+}
+
+func (opts *LoadOptions) readHeader(record []string, cols []int32)(bool, []int32, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Decides if the first row is a header and, if so, resolves the columns
+	//  selected by name
+	//	Input:   first row, data columns selected by number
+	//	Output:  true if the row is a header, data columns, error if a named column
+	//		 is missing
+	//-----------------------------------------------------------------------------------
+
+	var isHeader bool
+	var byName bool
+
+	byName=(opts.DataColName != "") || (opts.TimeColName != "") || (len(opts.DataColNames) > 0)
+
+	switch (opts.Header){
+	case HEADER_NONE:
+		isHeader=false
+	case HEADER_PRESENT:
+		isHeader=true
+	default:
+		//a header when every data cell holds text; an empty or absent cell is a
+		//missing value of a data row
+		isHeader=true
+		if (!byName){
+			for _, col := range cols{
+				if (!textCell(record,col)){
+					isHeader=false
+					break
+				}
+			}
+		}
+	}
+
+	if (!isHeader){
+		if (byName){
+			return false, cols, ErrNoHeader
+		}
+		return false, cols, nil
+	}
+
+	if (opts.TimeColName != ""){
+		col, err := findColumn(record,opts.TimeColName)
+		if (err != nil){
+			return true, cols, err
+		}
+		opts.TimeCol=col
+	}
+
+	if (len(opts.DataColNames) > 0){
+		cols=cols[:0]
+		for _, name := range opts.DataColNames{
+			col, err := findColumn(record,name)
+			if (err != nil){
+				return true, cols, err
+			}
+			cols=append(cols,col)
+		}
+	}else if (opts.DataColName != "") && (!opts.multiColumn()){
+		col, err := findColumn(record,opts.DataColName)
+		if (err != nil){
+			return true, cols, err
+		}
+		opts.DataCol=col
+		cols=[]int32{col}
+	}
+
+	//the time column is never data
+	if (opts.AllCols){
+		kept:=cols[:0]
+		for _, col := range cols{
+			if (col != opts.TimeCol){
+				kept=append(kept,col)
+			}
+		}
+		cols=kept
+	}
+
+	return true, cols, nil
+}
+
+func findColumn(header []string, name string)(int32, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Finds a column by name, ignoring case and surrounding space
+	//	Input:   header row, column name
+	//	Output:  1-based column number
+	//-----------------------------------------------------------------------------------
+
+	for index, title := range header{
+		if (strings.EqualFold(strings.TrimSpace(title),strings.TrimSpace(name))){
+			return int32(index+1), nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrUnknownColumn, name)
+}
+
+func columnName(header []string, col int32)(string){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the heading of a 1-based column, empty if there is none
+	//	Input:   header row, column number
+	//	Output:  column name
+	//-----------------------------------------------------------------------------------
+
+	if (col < 1) || (int(col) > len(header)){
+		return ""
+	}
+
+	return strings.TrimSpace(header[col-1])
+}
+
+func parseCell(fname string, r *csv.Reader, record []string, col int32, delim rune)(float64, *LoadError){
+
+	//-----------------------------------------------------------------------------------
+	//  Parses one data cell.  Empty, non-numeric, NaN and absent cells are missing
+	//	Input:   filename (for error reports only), reader positioned on the record,
+	//		 record, 1-based column, delimiter
+	//	Output:  value, error describing a missing value
+	//-----------------------------------------------------------------------------------
+
+	if (int(col) > len(record)){
+		line,_:=r.FieldPos(0)
+		return 0, &LoadError{File: fname, Line: int64(line), Column: col,
+			Text: strings.Join(record,string(delim)), Err: ErrMissingColumn}
+	}
+
+	text:=record[col-1]
+	f, err := strconv.ParseFloat(strings.TrimSpace(text),64)
+	if (err == nil) && (math.IsNaN(f)){
+		err=&strconv.NumError{Func: "ParseFloat", Num: text, Err: strconv.ErrSyntax}
+	}
+	if (err != nil){
+		line,_:=r.FieldPos(int(col-1))
+		return 0, &LoadError{File: fname, Line: int64(line), Column: col,
+			Text: text, Err: errors.Unwrap(err)}
+	}
+
+	return f, nil
+}
+
+func textCell(record []string, col int32)(bool){
+
+	//-----------------------------------------------------------------------------------
+	//  True if a cell holds text that is not a number.  Empty and absent cells are
+	//  missing values, not text
+	//	Input:   record, 1-based column
+	//	Output:  true for text
+	//-----------------------------------------------------------------------------------
+
+	if (int(col) > len(record)){
+		return false
+	}
+	text:=strings.TrimSpace(record[col-1])
+	if (text == ""){
+		return false
+	}
+	_, err := strconv.ParseFloat(text,64)
+
+	return err != nil
+}
+
+func hasValues(data DataT)(bool){
+
+	//-----------------------------------------------------------------------------------
+	//  True if the data holds at least one value that is not missing
+	//	Input:   data
+	//	Output:  true if a value is not NaN
+	//-----------------------------------------------------------------------------------
+
+	for _, value := range data{
+		if (!math.IsNaN(value)){
+			return true
+		}
+	}
+
+	return false
+}
+
+func readCSV(fname string, in io.Reader, opts LoadOptions)([]*Series, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Reads delimited data, one series per selected data column.  Every series
+	//  takes its labels from the same time column; a bad cell only drops the
+	//  sample from its own column
+	//	Input:   filename (for error reports only), data source, layout
+	//	Output:  series, error for the first bad cell (strict) or an unreadable source
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var seriesA []*Series
+	var cols []int32
+	var rows int64
+	var badCell *LoadError
+	var pending []LoadError
+	var label string
+	var stamp time.Time
+	var stampErr *LoadError
+	var picked bool
+
+	opts=opts.withDefaults()
+
+	// Create a new reader.
+	r:=csv.NewReader(bufio.NewReader(in))
+
+	//set the delimeter, rows may vary in length:
+	r.Comma=opts.Delim
+	r.FieldsPerRecord=-1
+
+	for {
+		record, err := r.Read()
+		// Stop at EOF.
+		if (err == io.EOF){
+			break
+		}
+
+		//the first row picks the columns and may be a header naming them
+		if (seriesA == nil) && (err == nil){
+			var isHeader bool
+
+			cols=opts.selectColumns(record)
+			isHeader, cols, err = opts.readHeader(record,cols)
+			if (err != nil){
+				line,_:=r.FieldPos(0)
+				return nil, &LoadError{File: fname, Line: int64(line), Err: err}
+			}
+
+			for _, col := range cols{
+				series:=new(Series)
+				if (isHeader){
+					series.Name=columnName(record,col)
+					series.TimeName=columnName(record,opts.TimeCol)
+				}else if (opts.multiColumn()){
+					series.Name=fmt.Sprintf("col%d",col)
+				}
+				series.Col=col
+				series.TimeCol=opts.TimeCol
+				series.Report.Skipped=append(series.Report.Skipped,pending...)
+				seriesA=append(seriesA,series)
+			}
+
+			if (isHeader){
+				continue
+			}
+		}
+
+		rows=rows+1
+
+		//a malformed row is bad for every column
+		if (err != nil){
+			var perr *csv.ParseError
+			if (!errors.As(err,&perr)){
+				return nil, err
+			}
+			badCell=&LoadError{File: fname, Line: int64(perr.Line), Err: perr.Err}
+			if (opts.Strict){
+				return nil, badCell
+			}
+			if (seriesA == nil){
+				pending=append(pending,*badCell)
+			}
+			for _, series := range seriesA{
+				series.Report.Skipped=append(series.Report.Skipped,*badCell)
+			}
+			continue
+		}
+
+		//all-column loads drop the columns whose first data row holds text
+		if (opts.AllCols) && (!picked){
+			picked=true
+			kept:=seriesA[:0]
+			keptCols:=cols[:0]
+			for index, series := range seriesA{
+				if (!textCell(record,cols[index])){
+					kept=append(kept,series)
+					keptCols=append(keptCols,cols[index])
+				}
+			}
+			seriesA=kept
+			cols=keptCols
+		}
+
+		//time label shared by all columns
+		label=""
+		stampErr=nil
+		if (opts.TimeCol != NO_TIME_COL) && (int(opts.TimeCol) <= len(record)){
+			label=record[opts.TimeCol-1]
+
+			if (opts.TimeLayout != ""){
+				stamp, err = parseTime(label,opts.TimeLayout,opts.TimeZone)
+				if (err != nil){
+					line,_:=r.FieldPos(int(opts.TimeCol-1))
+					stampErr=&LoadError{File: fname, Line: int64(line), Column: opts.TimeCol,
+						Text: label, Err: err}
+				}
+			}
+		}
+
+		for index, series := range seriesA{
+			col:=cols[index]
+			badCell=nil
+
+			if (int(opts.TimeCol) > len(record)){
+				line,_:=r.FieldPos(0)
+				badCell=&LoadError{File: fname, Line: int64(line), Column: opts.TimeCol,
+					Text: strings.Join(record,string(opts.Delim)), Err: ErrMissingColumn}
+			}else if (stampErr != nil){
+				badCell=stampErr
+			}else{
+				f, cellErr := parseCell(fname,r,record,col,opts.Delim)
+				if (cellErr != nil) && (opts.Missing == MISSING_DROP){
+					badCell=cellErr
+				}else{
+					//missing values are kept as NaN until filled
+					if (cellErr != nil){
+						f=math.NaN()
+						series.Report.Missing=append(series.Report.Missing,*cellErr)
+					}
+					line,_:=r.FieldPos(0)
+
+					series.Data=append(series.Data,f)
+					series.Lines=append(series.Lines,int64(line))
+
+					if (opts.TimeCol == NO_TIME_COL){
+						//use incremental values in place of time
+						series.Time=append(series.Time,fmt.Sprintf("%10d",len(series.Data)))
+					}else{
+						series.Time=append(series.Time,label)
+					}
+					if (opts.TimeLayout != "") && (opts.TimeCol != NO_TIME_COL){
+						series.Stamps=append(series.Stamps,stamp)
+					}
+				}
+			}
+
+			if (badCell != nil){
+				if (opts.Strict){
+					return nil, badCell
+				}
+				series.Report.Skipped=append(series.Report.Skipped,*badCell)
+			}
+		}
+	}
+
+	//all-column loads keep only the columns holding numbers
+	kept:=seriesA[:0]
+	for _, series := range seriesA{
+		series.Report.Rows=rows
+		series.Report.Accepted=int64(len(series.Data))
+		if (!opts.AllCols) || (hasValues(series.Data)){
+			fillMissing(series,opts.Missing)
+			if err:=applyGaps(series,opts); err != nil{
+				return nil, err
+			}
+			kept=append(kept,series)
+		}
+	}
+
+	return kept, nil
+}
+
+func readSeries(fname string, in io.Reader, opts LoadOptions)(*Series, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Reads the single data column selected by DataCol or DataColName
+	//	Input:   filename (for error reports only), data source, layout
+	//	Output:  series, error for the first bad row (strict) or an unreadable source
+	//-----------------------------------------------------------------------------------
+
+	opts.AllCols=false
+	opts.DataCols=nil
+	opts.DataColNames=nil
+
+	seriesA, err := readCSV(fname,in,opts)
+	if (err != nil){
+		return nil, err
+	}
+
+	//no rows at all
+	if (len(seriesA) == 0){
+		return new(Series), nil
+	}
+
+	return seriesA[0], nil
+}
+
+func LoadCSV(r io.Reader, opts LoadOptions)(*Series, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Reads delimited data from any reader (stdin, a pipe, an in-memory buffer)
+	//	Input:   data source, layout
+	//	Output:  series, error for the first bad row (strict) or an unreadable source
+	//-----------------------------------------------------------------------------------
+
+	return readSeries("",r,opts)
+}
+
+func LoadCSVColumns(r io.Reader, opts LoadOptions)([]*Series, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Reads every data column selected by AllCols, DataColNames or DataCols (in that
+	//  order of precedence) in one pass
+	//	Input:   data source, layout
+	//	Output:  one series per column, error for the first bad cell (strict) or an
+	//		 unreadable source
+	//-----------------------------------------------------------------------------------
+
+	return readCSV("",r,opts)
+}
+
+func GetColumnsFromFile(fname string, opts LoadOptions)([]*Series, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Reads every selected data column of a file in one pass, "-" reads stdin
+	//	Input:   filename, layout
+	//	Output:  one series per column, error if the file cannot be read, or for the
+	//		 first bad cell when strict
+	//-----------------------------------------------------------------------------------
+
+	if (fname == "-"){
+		return readCSV("stdin",os.Stdin,opts)
+	}
+
+	file, err := os.Open(fname)
+	if (err != nil){
+		return nil, err
+	}
+	defer file.Close()
+
+	return readCSV(fname,file,opts)
+}
+
+func (d *Detector) loadCSV(fname string, in io.Reader)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Reads delimited data and appends it to the detector.  Nothing is appended when
+	//  an error is returned
+	//	Input:   filename (for error reports only), data source
+	//	Output:  error for the first bad row (strict) or an unreadable source
+	//-----------------------------------------------------------------------------------
+
+	series, err := readSeries(fname,in,d.load)
+	if (err != nil){
+		return err
+	}
+
+	//breaks are relative to the start of this load
+	for _, brk := range series.Breaks{
+		d.breaks=append(d.breaks,brk+int64(len(d.rawData)))
+	}
+	d.rawData=append(d.rawData,series.Data...)
+	d.timeData=append(d.timeData,series.Time...)
+	d.stampData=append(d.stampData,series.Stamps...)
+	d.lineData=append(d.lineData,series.Lines...)
+	d.report=series.Report
+	d.column=series.Name
+	d.timeLabels=(series.TimeCol != NO_TIME_COL)
+
+	return nil
+}
+
+func (d *Detector) SetHeader(header int){
+
+	//-----------------------------------------------------------------------------------
+	//  Declares whether the data has a header row: HEADER_AUTO, HEADER_NONE or
+	//  HEADER_PRESENT
+	//	Input:   header mode
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.load.Header=header
+}
+
+func (d *Detector) SetTimeColName(name string){
+
+	//-----------------------------------------------------------------------------------
+	//  Selects the time column by its heading
+	//	Input:   column name
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.load.TimeColName=name
+}
+
+func (d *Detector) SetDataColName(name string){
+
+	//-----------------------------------------------------------------------------------
+	//  Selects the data column by its heading
+	//	Input:   column name
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.load.DataColName=name
+}
+
+func (d *Detector) LoadCSV(r io.Reader)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Takes data from a reader and appends it to the detector, using the detector's
+	//  delimiter and columns
+	//	Input:   data source
+	//	Output:  error if the source cannot be read, or on the first bad row when strict
+	//-----------------------------------------------------------------------------------
+
+	return d.loadCSV("",r)
+}
+
+func (d *Detector) GetDataFromFile(fname string)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Takes data from file and appends it to the detector.  A filename of "-" reads
+	//  stdin
+	//	Input:   filename
+	//	Output:  error if the file cannot be read, or on the first bad row when strict
+	//-----------------------------------------------------------------------------------
+
+	if (fname == "-"){
+		return d.loadCSV("stdin",os.Stdin)
+	}
+
+	file, err := os.Open(fname)
+	if (err != nil){
+		return err
+	}
+	defer file.Close()
+
+	return d.loadCSV(fname,file)
+}
@@ -0,0 +1,335 @@
+package cpd
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// ///////////////////// CONSTANTS
+const MERGE_RATIO = 0 // levels within ChgTolerance percent of each other
+const MERGE_ABS   = 1 // levels within MergeThresh of each other, in data units
+const MERGE_SIGMA = 2 // levels within MergeThresh pooled standard deviations
+const MERGE_WELCH = 3 // Welch t-test p-value above MergeThresh
+const MERGE_BOOT  = 4 // permutation test p-value above MergeThresh
+
+const DEF_MERGE_SIGMA = 0.5 // MERGE_SIGMA threshold, a medium effect size
+
+const MAX_PERM_RESAMPLES = 1000 // most random splits drawn by the permutation test
+
+const BETA_ITER = 200   // continued fraction terms for the incomplete beta
+const BETA_EPS  = 1e-14
+
+
+func (d *Detector) SetMerge(mode int, thresh float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Selects how subtle changes are recognized: MERGE_RATIO, MERGE_ABS,
+	//  MERGE_SIGMA, MERGE_WELCH or MERGE_BOOT.  A negative ChgTolerance still
+	//  disables merging
+	//	Input:   mode, threshold: a difference for MERGE_ABS, standard deviations for
+	//		 MERGE_SIGMA (0 for DEF_MERGE_SIGMA), a significance level for the
+	//		 tests (0 for 1-MinConf/100); unused by MERGE_RATIO
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.Merge=mode
+	d.opts.MergeThresh=thresh
+}
+
+func (opts Options) validateMerge()(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Checks the merge options
+	//	Input:
+	//	Output:  error wrapping ErrBadOption
+	//-----------------------------------------------------------------------------------
+
+	if (opts.Merge < MERGE_RATIO) || (opts.Merge > MERGE_BOOT){
+		return fmt.Errorf("%w: merge mode %d", ErrBadOption, opts.Merge)
+	}
+	if (opts.MergeThresh < 0) || (((opts.Merge == MERGE_WELCH) || (opts.Merge == MERGE_BOOT)) && (opts.MergeThresh > 1)){
+		return fmt.Errorf("%w: merge threshold %v", ErrBadOption, opts.MergeThresh)
+	}
+
+	return nil
+}
+
+func ratioPct(a, b float64)(int){
+
+	//-----------------------------------------------------------------------------------
+	//  Percentage by which two values differ, relative to the larger magnitude, as
+	//  used to flag subtle changes.  Safe for zero and negative values: values of
+	//  opposite sign differ by more than 100%
+	//	Input:   two values
+	//	Output:  rounded percentage
+	//-----------------------------------------------------------------------------------
+
+	scale:=math.Max(math.Abs(a),math.Abs(b))
+	if (scale == 0){
+		return 0
+	}
+
+	return int(math.Abs(100*math.Abs(a-b)/scale-0.5))
+}
+
+func (d *Detector) isSubtle(i, parent int64)(bool){
+
+	//-----------------------------------------------------------------------------------
+	//  Decides whether a change is too small to report on its own, against the
+	//  change it would be merged into.  Variance changes also need a spread within
+	//  ChgTolerance percent, slope changes are judged by the angle alone
+	//	Input:   change index, parent change index
+	//	Output:  true if subtle
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var subtle bool
+
+	cur:=&d.chgA[i]
+	prev:=&d.chgA[parent]
+	if (d.opts.ChgTolerance < 0){
+		return false
+	}
+
+	if (cur.Changed & CHG_SLOPE != 0){
+		return math.Abs(cur.Angle-prev.Angle) <= THETA_RANGE
+	}
+
+	level, spread := d.level(cur)
+	prevLevel, prevSpread := d.level(prev)
+	alpha:=d.opts.MergeThresh
+	if (alpha == 0){
+		alpha=1-d.opts.MinConf/100
+	}
+
+	switch (d.opts.Merge){
+	case MERGE_ABS:
+		subtle=math.Abs(level-prevLevel) <= d.opts.MergeThresh
+
+	case MERGE_SIGMA:
+		thresh:=d.opts.MergeThresh
+		if (thresh == 0){
+			thresh=DEF_MERGE_SIGMA
+		}
+		pooled:=math.Sqrt((spread*spread+prevSpread*prevSpread)/2)
+		subtle=(level == prevLevel) || (math.Abs(level-prevLevel) <= thresh*pooled)
+
+	case MERGE_WELCH:
+		subtle=welchPValue(d.segmentData(parent),d.segmentData(i)) > alpha
+
+	case MERGE_BOOT:
+		subtle=d.permPValue(d.segmentData(parent),d.segmentData(i),
+			mixSeed(d.seed,-2,prev.Index,cur.Index)) > alpha
+
+	default:
+		subtle=ratioPct(level,prevLevel) <= d.opts.ChgTolerance
+	}
+
+	if (cur.Changed & CHG_VAR != 0){
+		subtle=subtle && (ratioPct(spread,prevSpread) <= d.opts.ChgTolerance)
+	}
+
+	return subtle
+}
+
+func (d *Detector) segmentData(i int64)([]float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Samples of the segment a change starts, up to the next change
+	//	Input:   change index, not the dummy end
+	//	Output:  data slice
+	//-----------------------------------------------------------------------------------
+
+	return d.rawData[d.chgA[i].Index:d.chgA[i+1].Index]
+}
+
+func sampleStats(data []float64)(float64, float64, float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Count, mean and sample variance, NaN values are skipped
+	//	Input:   data
+	//	Output:  count, mean, variance (n-1 denominator, 0 below two values)
+	//-----------------------------------------------------------------------------------
+
+	var n,mean,ss float64
+
+	for _, value := range data{
+		if (math.IsNaN(value)){
+			continue
+		}
+		n=n+1
+		delta:=value-mean
+		mean=mean+delta/n
+		ss=ss+delta*(value-mean)
+	}
+	if (n < 2){
+		return n, mean, 0
+	}
+
+	return n, mean, ss/(n-1)
+}
+
+func welchPValue(a, b []float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Two sided Welch t-test for different means, unequal variances allowed
+	//	Input:   samples of both segments
+	//	Output:  p-value, 1 if either segment has fewer than two values
+	//-----------------------------------------------------------------------------------
+
+	n1, m1, v1 := sampleStats(a)
+	n2, m2, v2 := sampleStats(b)
+	if (n1 < 2) || (n2 < 2){
+		return 1
+	}
+
+	se2:=v1/n1+v2/n2
+	if (se2 == 0){
+		if (m1 == m2){
+			return 1
+		}
+		return 0
+	}
+
+	t:=(m1-m2)/math.Sqrt(se2)
+	df:=se2*se2/((v1/n1)*(v1/n1)/(n1-1)+(v2/n2)*(v2/n2)/(n2-1))
+
+	return incBeta(df/2,0.5,df/(df+t*t))
+}
+
+func incBeta(a, b, x float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Regularized incomplete beta function I_x(a,b), by its continued fraction
+	//	Input:   a, b > 0, x in 0..1
+	//	Output:  I_x(a,b)
+	//-----------------------------------------------------------------------------------
+
+	if (x <= 0){
+		return 0
+	}
+	if (x >= 1){
+		return 1
+	}
+
+	lgA, _ := math.Lgamma(a)
+	lgB, _ := math.Lgamma(b)
+	lgAB, _ := math.Lgamma(a+b)
+	front:=math.Exp(lgAB-lgA-lgB+a*math.Log(x)+b*math.Log(1-x))
+
+	//the fraction converges fast below the mean, use the symmetry above it
+	if (x > (a+1)/(a+b+2)){
+		return 1-front*betaFrac(b,a,1-x)/b
+	}
+
+	return front*betaFrac(a,b,x)/a
+}
+
+func betaFrac(a, b, x float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Continued fraction of the incomplete beta function (modified Lentz)
+	//	Input:   a, b, x
+	//	Output:  fraction
+	//-----------------------------------------------------------------------------------
+
+	const tiny = 1e-300
+
+	c:=1.0
+	dd:=1-(a+b)*x/(a+1)
+	if (math.Abs(dd) < tiny){
+		dd=tiny
+	}
+	dd=1/dd
+	h:=dd
+
+	for m := 1; m <= BETA_ITER; m++ {
+		fm:=float64(m)
+
+		//even step
+		num:=fm*(b-fm)*x/((a+2*fm-1)*(a+2*fm))
+		dd=1+num*dd
+		if (math.Abs(dd) < tiny){
+			dd=tiny
+		}
+		c=1+num/c
+		if (math.Abs(c) < tiny){
+			c=tiny
+		}
+		dd=1/dd
+		h=h*dd*c
+
+		//odd step
+		num=-(a+fm)*(a+b+fm)*x/((a+2*fm)*(a+2*fm+1))
+		dd=1+num*dd
+		if (math.Abs(dd) < tiny){
+			dd=tiny
+		}
+		c=1+num/c
+		if (math.Abs(c) < tiny){
+			c=tiny
+		}
+		dd=1/dd
+		delta:=dd*c
+		h=h*delta
+
+		if (math.Abs(delta-1) < BETA_EPS){
+			break
+		}
+	}
+
+	return h
+}
+
+func (d *Detector) permPValue(a, b []float64, seed int64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Permutation test for different levels (means, medians in robust mode): the
+	//  share of random splits of the pooled samples whose levels differ at least
+	//  as much, counting the data itself
+	//	Input:   samples of both segments, seed
+	//	Output:  p-value, 1 if either segment has no values
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var pool []float64
+	var count int64
+
+	center:=calcAvg
+	if (d.opts.Robust){
+		center=calcMedian
+	}
+
+	for _, value := range a{
+		if (!math.IsNaN(value)){
+			pool=append(pool,value)
+		}
+	}
+	n1:=len(pool)
+	for _, value := range b{
+		if (!math.IsNaN(value)){
+			pool=append(pool,value)
+		}
+	}
+	if (n1 == 0) || (n1 == len(pool)){
+		return 1
+	}
+
+	orig:=math.Abs(center(pool[:n1])-center(pool[n1:]))
+
+	resamples:=d.opts.Bootstrap
+	if (resamples > MAX_PERM_RESAMPLES){
+		resamples=MAX_PERM_RESAMPLES
+	}
+	rng:=rand.New(rand.NewSource(seed))
+
+	for r := int64(0); r < resamples; r++ {
+		rng.Shuffle(len(pool),func(i, j int){ pool[i], pool[j] = pool[j], pool[i] })
+		if (math.Abs(center(pool[:n1])-center(pool[n1:])) >= orig){
+			count++
+		}
+	}
+
+	return float64(count+1)/float64(resamples+1)
+}
@@ -0,0 +1,89 @@
+package cpd
+
+import (
+	"math"
+)
+
+// ///////////////////// CONSTANTS
+const MISSING_DROP   = 0 // skip the row, line numbers still refer to the file
+const MISSING_FILL   = 1 // repeat the previous value
+const MISSING_INTERP = 2 // interpolate linearly between neighbouring values
+const MISSING_NAN    = 3 // keep as NaN, excluded from the statistics
+
+
+func (d *Detector) SetMissing(policy int){
+
+	//-----------------------------------------------------------------------------------
+	//  Sets how empty or non-numeric data cells are handled: MISSING_DROP,
+	//  MISSING_FILL, MISSING_INTERP or MISSING_NAN
+	//	Input:   policy
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.load.Missing=policy
+}
+
+func fillMissing(series *Series, policy int){
+
+	//-----------------------------------------------------------------------------------
+	//  Replaces the NaN placeholders left by the loader.  Values missing at either
+	//  end take the nearest value
+	//	Input:   series, policy
+	//	Output:  series updated in place
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var prev int
+
+	if (policy != MISSING_FILL) && (policy != MISSING_INTERP){
+		return
+	}
+
+	data:=series.Data
+	prev=-1
+
+	for i := range data{
+		if (math.IsNaN(data[i])){
+			continue
+		}
+
+		//fill the run of missing values before this one
+		for j := prev+1; j < i; j++ {
+			switch {
+			case prev < 0:
+				data[j]=data[i]
+			case policy == MISSING_FILL:
+				data[j]=data[prev]
+			default:
+				data[j]=data[prev]+(data[i]-data[prev])*series.position(prev,j,i)
+			}
+		}
+		prev=i
+	}
+
+	//trailing run
+	if (prev >= 0){
+		for j := prev+1; j < len(data); j++ {
+			data[j]=data[prev]
+		}
+	}
+}
+
+func (series *Series) position(prev, j, next int)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Fraction of the way from sample prev to sample next at which sample j lies,
+	//  by time stamp when the series has them
+	//	Input:   sample indexes, prev < j < next
+	//	Output:  fraction between 0 and 1
+	//-----------------------------------------------------------------------------------
+
+	if (len(series.Stamps) == len(series.Data)){
+		span:=series.Stamps[next].Sub(series.Stamps[prev])
+		if (span > 0){
+			return float64(series.Stamps[j].Sub(series.Stamps[prev]))/float64(span)
+		}
+	}
+
+	return float64(j-prev)/float64(next-prev)
+}
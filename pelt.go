@@ -0,0 +1,410 @@
+package cpd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+)
+
+// ///////////////////// CONSTANTS
+const METHOD_BINSEG = 0 // recursive CUSUM with bootstrap confidence
+const METHOD_PELT   = 1 // pruned exact linear time optimal partitioning
+
+const COST_NORMAL_MEAN    = 0 // change in mean, variance estimated once
+const COST_NORMAL_MEANVAR = 1 // change in mean and/or variance
+const COST_POISSON        = 2 // change in rate of count data
+const COST_NONPARAM       = 3 // change in distribution (empirical, ED-PELT)
+
+const PEN_MBIC   = 0 // 3 log n
+const PEN_BIC    = 1 // parameters * log n
+const PEN_AIC    = 2 // 2 * parameters
+const PEN_MANUAL = 3 // Options.PenaltyValue
+
+const VAR_FLOOR = 0.05 // least COST_NORMAL_MEANVAR segment variance, as a share of
+                       // the noise variance, so a few close samples are not a segment
+
+
+// ///////////////////// ERRORS
+var ErrNegativeCount = errors.New("cpd: poisson cost needs non-negative data")
+
+
+// ///////////////////// TYPES
+
+// segCost gives the cost of the segment data[s:t] from prefix sums built once
+// for the whole series.
+type segCost struct {
+	kind   int
+	sigma2 float64     // noise variance, COST_NORMAL_MEAN and COST_NORMAL_MEANVAR
+	n      []float64   // prefix count of non-missing values
+	s1     []float64   // prefix sum
+	s2     []float64   // prefix sum of squares
+	cum    [][]float64 // COST_NONPARAM prefix counts below each quantile
+	npC    float64     // COST_NONPARAM scale, -log(2n-1)
+}
+
+
+func (d *Detector) SetMethod(method int){
+
+	//-----------------------------------------------------------------------------------
+	//  Selects the detector: METHOD_BINSEG or METHOD_PELT
+	//	Input:   method
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.Method=method
+}
+
+func (d *Detector) SetCost(cost int){
+
+	//-----------------------------------------------------------------------------------
+	//  Segment cost used by PELT: COST_NORMAL_MEAN, COST_NORMAL_MEANVAR, COST_POISSON
+	//  or COST_NONPARAM
+	//	Input:   cost function
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.Cost=cost
+}
+
+func (d *Detector) SetPenalty(penalty int, value float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Penalty added per change by PELT: PEN_MBIC, PEN_BIC, PEN_AIC or PEN_MANUAL
+	//	Input:   penalty, value for PEN_MANUAL
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.Penalty=penalty
+	d.opts.PenaltyValue=value
+}
+
+func costParams(kind int)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Parameters estimated per segment by a cost function
+	//	Input:   cost function
+	//	Output:  parameter count
+	//-----------------------------------------------------------------------------------
+
+	if (kind == COST_NORMAL_MEANVAR){
+		return 2
+	}
+
+	return 1
+}
+
+func penaltyValue(opts Options, n int)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Penalty per change for a series of length n
+	//	Input:   options, series length
+	//	Output:  penalty
+	//-----------------------------------------------------------------------------------
+
+	logN:=math.Log(float64(n))
+
+	switch (opts.Penalty){
+	case PEN_BIC:
+		return costParams(opts.Cost)*logN
+	case PEN_AIC:
+		return 2*costParams(opts.Cost)
+	case PEN_MANUAL:
+		return opts.PenaltyValue
+	}
+
+	return 3*logN
+}
+
+func newSegCost(kind int, data []float64)(*segCost, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Builds the prefix sums for a cost function.  NaN (missing) values are skipped
+	//	Input:   cost function, data
+	//	Output:  cost, error if the data does not suit the cost
+	//-----------------------------------------------------------------------------------
+
+	c:=&segCost{kind: kind}
+	c.n=make([]float64,len(data)+1)
+	c.s1=make([]float64,len(data)+1)
+	c.s2=make([]float64,len(data)+1)
+
+	for i, value := range data{
+		c.n[i+1]=c.n[i]
+		c.s1[i+1]=c.s1[i]
+		c.s2[i+1]=c.s2[i]
+		if (math.IsNaN(value)){
+			continue
+		}
+		if (kind == COST_POISSON) && (value < 0){
+			return nil, ErrNegativeCount
+		}
+		c.n[i+1]=c.n[i+1]+1
+		c.s1[i+1]=c.s1[i+1]+value
+		c.s2[i+1]=c.s2[i+1]+value*value
+	}
+
+	switch (kind){
+	case COST_NORMAL_MEAN, COST_NORMAL_MEANVAR:
+		c.sigma2=diffVariance(data)
+	case COST_NONPARAM:
+		c.buildQuantiles(data)
+	}
+
+	return c, nil
+}
+
+func diffVariance(data []float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Robust noise variance, from the MAD of successive differences, so that level
+	//  shifts do not inflate it
+	//	Input:   data
+	//	Output:  variance, 1 if it cannot be estimated
+	//-----------------------------------------------------------------------------------
+
+	var diffs []float64
+
+	for i := 1; i < len(data); i++ {
+		diff:=data[i]-data[i-1]
+		if (!math.IsNaN(diff)){
+			diffs=append(diffs,diff)
+		}
+	}
+	if (len(diffs) == 0){
+		return 1
+	}
+
+	sigma:=calcMAD(diffs,calcMedian(diffs))*MAD_SCALE/math.Sqrt2
+	if (sigma <= 0){
+		//fall back to the plain standard deviation of the differences
+		sigma=calcStdev(diffs,calcAvg(diffs))/math.Sqrt2
+	}
+	if (sigma <= 0){
+		return 1
+	}
+
+	return sigma*sigma
+}
+
+func (c *segCost) buildQuantiles(data []float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Prefix counts below the quantiles used by the empirical cost (Haynes, Fearnhead
+	//  and Eckley 2017), quantiles spaced more densely in the tails
+	//	Input:   data
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	var sorted []float64
+
+	for _, value := range data{
+		if (!math.IsNaN(value)){
+			sorted=append(sorted,value)
+		}
+	}
+	n:=len(sorted)
+	if (n < 2){
+		return
+	}
+	sort.Float64s(sorted)
+
+	k:=int(math.Ceil(4*math.Log(float64(n))))
+	if (k > n){
+		k=n
+	}
+	c.npC=-math.Log(float64(2*n-1))
+	c.cum=make([][]float64,k)
+
+	for q := 0; q < k; q++ {
+		p:=1/(1+float64(2*n-1)*math.Exp(c.npC/float64(k)*float64(2*q+1)))
+		level:=quantile(sorted,p)
+
+		cum:=make([]float64,len(data)+1)
+		for i, value := range data{
+			cum[i+1]=cum[i]
+			switch {
+			case math.IsNaN(value):
+			case value < level:
+				cum[i+1]=cum[i+1]+1
+			case value == level:
+				cum[i+1]=cum[i+1]+0.5
+			}
+		}
+		c.cum[q]=cum
+	}
+}
+
+func (c *segCost) cost(s, t int)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Twice the negative log likelihood of data[s:t] under the cost's model
+	//	Input:   segment bounds, t exclusive
+	//	Output:  cost
+	//-----------------------------------------------------------------------------------
+
+	n:=c.n[t]-c.n[s]
+	if (n == 0){
+		return 0
+	}
+	s1:=c.s1[t]-c.s1[s]
+	s2:=c.s2[t]-c.s2[s]
+
+	switch (c.kind){
+	case COST_NORMAL_MEANVAR:
+		variance:=(s2-s1*s1/n)/n
+		if (variance < VAR_FLOOR*c.sigma2){
+			variance=VAR_FLOOR*c.sigma2
+		}
+		return n*(math.Log(2*math.Pi)+math.Log(variance)+1)
+
+	case COST_POISSON:
+		if (s1 == 0){
+			return 0
+		}
+		return 2*(s1-s1*math.Log(s1/n))
+
+	case COST_NONPARAM:
+		var sum float64
+		for _, cum := range c.cum{
+			f:=(cum[t]-cum[s])/n
+			if (f > 0) && (f < 1){
+				sum=sum+n*(f*math.Log(f)+(1-f)*math.Log(1-f))
+			}
+		}
+		if (len(c.cum) == 0){
+			return 0
+		}
+		return 2*c.npC/float64(len(c.cum))*sum
+	}
+
+	return (s2-s1*s1/n)/c.sigma2
+}
+
+func pelt(ctx context.Context, data []float64, opts Options)([]int64, []float64, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Optimal partitioning with pruning (Killick, Fearnhead and Eckley 2012).  Finds
+	//  the change points minimizing total segment cost plus a penalty per change
+	//	Input:   context, data, options (cost, penalty)
+	//	Output:  indexes of the first sample of each new segment, cost saved by each
+	//		 change against merging its two segments, error if cancelled or the
+	//		 data does not suit the cost
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var cands,kept []int
+	var best float64
+	var bestTau int
+
+	n:=len(data)
+	if (n == 0){
+		return nil, nil, nil
+	}
+
+	c, err := newSegCost(opts.Cost,data)
+	if (err != nil){
+		return nil, nil, err
+	}
+	pen:=penaltyValue(opts,n)
+
+	//variance needs two points per segment
+	minLen:=1
+	if (opts.Cost != COST_NORMAL_MEAN) && (opts.Cost != COST_POISSON){
+		minLen=2
+	}
+	if (opts.MinLen > minLen){
+		minLen=opts.MinLen
+	}
+
+	f:=make([]float64,n+1)
+	last:=make([]int,n+1)
+	f[0]=-pen
+	cands=[]int{0}
+
+	for t := 1; t <= n; t++ {
+		if (t % 1024 == 0){
+			if err:=ctx.Err(); err != nil{
+				return nil, nil, err
+			}
+		}
+
+		best=math.Inf(1)
+		bestTau=0
+		for _, tau := range cands{
+			if (t-tau < minLen){
+				continue
+			}
+			value:=f[tau]+c.cost(tau,t)+pen
+			if (value < best){
+				best=value
+				bestTau=tau
+			}
+		}
+		f[t]=best
+		last[t]=bestTau
+
+		//prune candidates that can never be optimal again
+		kept=cands[:0]
+		for _, tau := range cands{
+			if (t-tau < minLen) || (math.IsInf(best,1)) || (f[tau]+c.cost(tau,t) <= best){
+				kept=append(kept,tau)
+			}
+		}
+		cands=append(kept,t)
+	}
+
+	//walk back through the optimal segmentation
+	var chgPts []int64
+	for t := last[n]; t > 0; t=last[t] {
+		chgPts=append(chgPts,int64(t))
+	}
+	sort.Slice(chgPts,func(i, j int) bool { return chgPts[i] < chgPts[j] })
+
+	//cost saved by each change, between its neighbours
+	gains:=make([]float64,len(chgPts))
+	for i, chgPt := range chgPts{
+		prev:=0
+		if (i > 0){
+			prev=int(chgPts[i-1])
+		}
+		next:=n
+		if (i+1 < len(chgPts)){
+			next=int(chgPts[i+1])
+		}
+		gains[i]=c.cost(prev,next)-c.cost(prev,int(chgPt))-c.cost(int(chgPt),next)
+	}
+
+	return chgPts, gains, nil
+}
+
+func (d *Detector) findChangePELT(ctx context.Context, start, end int64)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Runs PELT on data[start:end] and records the changes.  The changes are exact
+	//  for the penalty, so they carry a confidence of 100 and no p-value; their
+	//  cost reduction ranks them instead
+	//	Input:   context, piece bounds, end exclusive
+	//	Output:  error if cancelled or the data does not suit the cost
+	//-----------------------------------------------------------------------------------
+
+	var oneChg ChgT
+
+	chgPts, gains, err := pelt(ctx,d.rawData[start:end],d.opts)
+	if (err != nil){
+		return err
+	}
+
+	for i, chgPt := range chgPts{
+		oneChg.Index=start+chgPt
+		oneChg.Conf=100
+		oneChg.Strength=gains[i]
+		oneChg.Changed=CHG_MEAN
+		if (d.opts.Cost == COST_NORMAL_MEANVAR) || (d.opts.Cost == COST_NONPARAM){
+			oneChg.Changed=CHG_MEAN|CHG_VAR
+		}
+		d.chgA=append(d.chgA,oneChg)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package cpd
+
+import (
+	"math"
+	"sort"
+)
+
+// ///////////////////// CONSTANTS
+const MAD_SCALE = 1.4826 // MAD to standard deviation, for normal data
+
+
+func (d *Detector) SetRobust(robust bool){
+
+	//-----------------------------------------------------------------------------------
+	//  Tests ranks instead of values, so single outliers cannot make a change, and
+	//  reports segments by Median and MAD
+	//	Input:   true for robust detection
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.Robust=robust
+}
+
+func calcRanks(data []float64)([]float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Ranks of the values, 1 for the smallest and tied values sharing their mean
+	//  rank.  The cusum of the ranks is Pettitt's statistic, a Mann-Whitney test at
+	//  every split.  NaN (missing) values stay NaN
+	//	Input:   data
+	//	Output:  ranks
+	//-----------------------------------------------------------------------------------
+
+	var order []int
+
+	ranks:=make([]float64,len(data))
+	for i, value := range data{
+		if (math.IsNaN(value)){
+			ranks[i]=math.NaN()
+			continue
+		}
+		order=append(order,i)
+	}
+	sort.SliceStable(order,func(i, j int) bool { return data[order[i]] < data[order[j]] })
+
+	for lo := 0; lo < len(order); {
+		hi:=lo+1
+		for (hi < len(order)) && (data[order[hi]] == data[order[lo]]) {
+			hi++
+		}
+		rank:=float64(lo+hi+1)/2
+		for k := lo; k < hi; k++ {
+			ranks[order[k]]=rank
+		}
+		lo=hi
+	}
+
+	return ranks
+}
+
+func absDeviations(data []float64, median float64)([]float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Absolute deviations from the median, their ranks move with the spread.  NaN
+	//  (missing) values stay NaN
+	//	Input:   data, median
+	//	Output:  absolute deviations
+	//-----------------------------------------------------------------------------------
+
+	dev:=make([]float64,len(data))
+	for i, value := range data{
+		dev[i]=math.Abs(value-median)
+	}
+
+	return dev
+}
+
+func (d *Detector) level(chg *ChgT)(float64, float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Level and spread of a segment: Avg and Stdev, or Median and scaled MAD in
+	//  robust mode
+	//	Input:   summarized change
+	//	Output:  level, spread
+	//-----------------------------------------------------------------------------------
+
+	if (d.opts.Robust){
+		return chg.Median, chg.MAD*MAD_SCALE
+	}
+
+	return chg.Avg, chg.Stdev
+}
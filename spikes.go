@@ -0,0 +1,179 @@
+package cpd
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// ///////////////////// CONSTANTS
+const SPIKE_KEEP      = 0 // no pre-pass, spikes are analyzed like any data
+const SPIKE_EXCLUDE   = 1 // spikes are treated as missing values
+const SPIKE_WINSORIZE = 2 // spikes are clipped to the threshold
+
+const DEF_SPIKE_LEN    = 3 // longest run of samples reported as a spike
+const DEF_SPIKE_THRESH = 5 // distance from the local median, in scaled MADs
+
+
+// ///////////////////// TYPES
+
+// AnomalyT is a spike: a run of at most SpikeLen samples far from their local
+// median.  Longer runs are left to the change detection as level shifts.
+type AnomalyT struct {
+	StartIndex int64 // sample index, 0-based, end inclusive
+	EndIndex   int64
+	StartLine  int64
+	EndLine    int64
+	StartTime  string
+	EndTime    string
+	StartTS    time.Time // zero unless the time column was parsed
+	EndTS      time.Time
+	Values     []float64 // samples as loaded
+	Peak       float64   // sample furthest from the local median
+	Score      float64   // distance of the peak, in scaled MADs
+	Direction  int       // +1 up, -1 down
+}
+
+
+func (d *Detector) SetSpikes(policy, maxLen int, thresh float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Sets the spike pre-pass: SPIKE_KEEP, SPIKE_EXCLUDE or SPIKE_WINSORIZE.  Runs
+	//  of up to maxLen samples more than thresh scaled MADs from the local median
+	//  are reported as anomalies instead of changes
+	//	Input:   policy, longest spike (0 for DEF_SPIKE_LEN), threshold (0 for
+	//		 DEF_SPIKE_THRESH)
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	if (maxLen == 0){
+		maxLen=DEF_SPIKE_LEN
+	}
+	if (thresh == 0){
+		thresh=DEF_SPIKE_THRESH
+	}
+
+	d.opts.Spikes=policy
+	d.opts.SpikeLen=maxLen
+	d.opts.SpikeThresh=thresh
+}
+
+func (d *Detector) GetAnomalies()([]AnomalyT){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the spikes found by the last analysis
+	//	Input:
+	//	Output:  anomalies, in data order
+	//-----------------------------------------------------------------------------------
+
+	return d.anomalies
+}
+
+func medianFilter(data []float64, half int)([]float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Running median over 2*half+1 samples, shorter at the ends.  It follows level
+	//  shifts but not runs shorter than half+1 samples.  NaN (missing) values are
+	//  skipped
+	//	Input:   data, half window
+	//	Output:  local medians, NaN where the window holds no values
+	//-----------------------------------------------------------------------------------
+
+	var window []float64
+
+	med:=make([]float64,len(data))
+	for i := range data{
+		window=window[:0]
+		for j := i-half; j <= i+half; j++ {
+			if (j >= 0) && (j < len(data)) && (!math.IsNaN(data[j])){
+				window=append(window,data[j])
+			}
+		}
+		if (len(window) == 0){
+			med[i]=math.NaN()
+			continue
+		}
+		sort.Float64s(window)
+		med[i]=quantile(window,0.5)
+	}
+
+	return med
+}
+
+func (d *Detector) findSpikes(data []float64)([]float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Finds the spikes, records them as anomalies and returns the data to analyze,
+	//  with the spikes removed or clipped as the options say
+	//	Input:   loaded data
+	//	Output:  cleaned copy of the data
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var anomaly AnomalyT
+	var runEnd int
+
+	maxLen:=d.opts.SpikeLen
+	thresh:=d.opts.SpikeThresh
+	clean:=append([]float64(nil), data...)
+
+	//distance from the local median, scaled by its spread over the whole series
+	med:=medianFilter(data,maxLen)
+	resid:=make([]float64,len(data))
+	for i, value := range data{
+		resid[i]=value-med[i]
+	}
+	scale:=calcMAD(resid,calcMedian(resid))*MAD_SCALE
+	if (scale <= 0) || (math.IsNaN(scale)){
+		//flat data, fall back to the standard deviation
+		scale=calcStdev(resid,calcAvg(resid))
+	}
+	if (scale <= 0) || (math.IsNaN(scale)){
+		return clean
+	}
+
+	for i := 0; i < len(data); i=runEnd {
+		runEnd=i+1
+		if (math.IsNaN(resid[i])) || (math.Abs(resid[i]) <= thresh*scale){
+			continue
+		}
+
+		//a spike runs while the samples stay out on the same side
+		dir:=1
+		if (resid[i] < 0){
+			dir=-1
+		}
+		for (runEnd < len(data)) && (float64(dir)*resid[runEnd] > thresh*scale) {
+			runEnd++
+		}
+		if (runEnd-i > maxLen){
+			continue
+		}
+
+		anomaly=AnomalyT{StartIndex: int64(i), EndIndex: int64(runEnd-1), Direction: dir}
+		anomaly.StartLine=d.lineAt(anomaly.StartIndex)
+		anomaly.EndLine=d.lineAt(anomaly.EndIndex)
+		anomaly.StartTime=d.timeData[i]
+		anomaly.EndTime=d.timeData[runEnd-1]
+		anomaly.StartTS=d.stampAt(anomaly.StartIndex)
+		anomaly.EndTS=d.stampAt(anomaly.EndIndex)
+		anomaly.Values=append([]float64(nil), data[i:runEnd]...)
+
+		for j := i; j < runEnd; j++ {
+			if (math.Abs(resid[j])/scale > anomaly.Score){
+				anomaly.Score=math.Abs(resid[j])/scale
+				anomaly.Peak=data[j]
+			}
+
+			switch (d.opts.Spikes){
+			case SPIKE_EXCLUDE:
+				clean[j]=math.NaN()
+			case SPIKE_WINSORIZE:
+				clean[j]=med[j]+float64(dir)*thresh*scale
+			}
+		}
+		d.anomalies=append(d.anomalies,anomaly)
+	}
+
+	return clean
+}
@@ -0,0 +1,235 @@
+package cpd
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// ///////////////////// CONSTANTS
+const DEF_CI_BOOTSTRAP = 1000 // most resamples used for a segment mean interval
+
+
+func (d *Detector) SetMinConf(conf float64)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Sets the confidence (percent) a change needs to be reported.  It is also the
+	//  level of the segment mean intervals
+	//	Input:   confidence, up to 100 (0 for DEF_MIN_CONF)
+	//	Output:  error if out of range, the confidence is then unchanged
+	//-----------------------------------------------------------------------------------
+
+	if (conf == 0){
+		conf=DEF_MIN_CONF
+	}
+	if (conf < 0) || (conf > 100) || (math.IsNaN(conf)){
+		return fmt.Errorf("%w: min confidence %v", ErrBadOption, conf)
+	}
+
+	d.opts.MinConf=conf
+	return nil
+}
+
+func bootPValue(conf float64, iters int64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Bootstrap p-value of a change: the share of shuffles at least as extreme as
+	//  the data, counting the data itself
+	//	Input:   confidence in percent, shuffles used
+	//	Output:  p-value; 1 for boundaries that are not changes, NaN for changes
+	//		 found without a bootstrap (PELT)
+	//-----------------------------------------------------------------------------------
+
+	if (iters <= 0){
+		if (conf > 0){
+			return math.NaN()
+		}
+		return 1
+	}
+
+	gtCount:=math.Round(conf*float64(iters)/100)
+	return (float64(iters)-gtCount+1)/(float64(iters)+1)
+}
+
+func meanCI(data []float64, level float64, resamples int64, seed int64)(float64, float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Percentile bootstrap interval for the mean, NaN values are skipped
+	//	Input:   data, confidence level in percent, resamples, seed
+	//	Output:  low and high bound, NaN without values or resamples
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var values []float64
+	var sum float64
+
+	if (resamples <= 0){
+		return math.NaN(), math.NaN()
+	}
+	for _, value := range data{
+		if (!math.IsNaN(value)){
+			values=append(values,value)
+		}
+	}
+	if (len(values) == 0){
+		return math.NaN(), math.NaN()
+	}
+
+	rng:=rand.New(rand.NewSource(seed))
+	means:=make([]float64,resamples)
+
+	for b := range means{
+		sum=0
+		for range values{
+			sum=sum+values[rng.Intn(len(values))]
+		}
+		means[b]=sum/float64(len(values))
+	}
+	sort.Float64s(means)
+
+	alpha:=(1-level/100)/2
+	return quantile(means,alpha), quantile(means,1-alpha)
+}
+
+func (d *Detector) segmentCIs(lists ...[]ChgT){
+
+	//-----------------------------------------------------------------------------------
+	//  Fills in the mean intervals of the segments.  A segment listed more than once,
+	//  as most are in the changes and their summary, is bootstrapped once, and the
+	//  segments run on the worker pool when there is one.  Each is seeded from its
+	//  bounds, so the intervals do not depend on the number of workers
+	//	Input:   lists of changes with sample indexes filled in
+	//	Output:  AvgLow and AvgHigh updated in place
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var keys [][2]int64
+	var wg sync.WaitGroup
+
+	resamples:=d.opts.Bootstrap
+	if (resamples > DEF_CI_BOOTSTRAP){
+		resamples=DEF_CI_BOOTSTRAP
+	}
+
+	bounds:=make(map[[2]int64][2]float64)
+	for _, chgA := range lists{
+		for _, chg := range chgA{
+			key:=[2]int64{chg.ChgStartIndex,chg.ChgEndIndex}
+			if _, ok := bounds[key]; !ok{
+				bounds[key]=[2]float64{}
+				keys=append(keys,key)
+			}
+		}
+	}
+
+	results:=make([][2]float64,len(keys))
+	for i, key := range keys{
+		i, key := i, key
+		job:=func(){
+			results[i][0],results[i][1]=meanCI(d.rawData[key[0]:key[1]+1],d.opts.MinConf,resamples,
+				mixSeed(d.seed,-1,key[0],key[1]))
+		}
+		if (d.pool == nil){
+			job()
+			continue
+		}
+		wg.Add(1)
+		d.pool.jobs <- func(){
+			defer wg.Done()
+			job()
+		}
+	}
+	wg.Wait()
+
+	for i, key := range keys{
+		bounds[key]=results[i]
+	}
+	for _, chgA := range lists{
+		for i := range chgA{
+			ci:=bounds[[2]int64{chgA[i].ChgStartIndex,chgA[i].ChgEndIndex}]
+			chgA[i].AvgLow,chgA[i].AvgHigh=ci[0],ci[1]
+		}
+	}
+}
+
+func quantile(sorted []float64, q float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Quantile of sorted data, interpolating between neighbours
+	//	Input:   sorted data, quantile 0..1
+	//	Output:  value, NaN for no data
+	//-----------------------------------------------------------------------------------
+
+	if (len(sorted) == 0){
+		return math.NaN()
+	}
+
+	pos:=q*float64(len(sorted)-1)
+	lo:=int(math.Floor(pos))
+	hi:=int(math.Ceil(pos))
+
+	return sorted[lo]+(sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+func (d *Detector) statsPass(chgA []ChgT){
+
+	//-----------------------------------------------------------------------------------
+	//  Adds the statistical report to summarized changes: p-value, shift from the
+	//  previous segment, effect size and variance ratio; segmentCIs adds the
+	//  intervals.  Ratios over a zero denominator are NaN.  Robust mode compares
+	//  medians and MADs instead
+	//	Input:   changes with averages and sample indexes filled in
+	//	Output:  changes updated in place
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var n1,n2 float64
+	var pooled float64
+
+	for i := range chgA{
+		chg:=&chgA[i]
+
+		chg.PValue=bootPValue(chg.Conf,chg.Iterations)
+
+		if (i == 0){
+			continue
+		}
+
+		//compare with the previous segment
+		prev:=&chgA[i-1]
+		level, spread := d.level(chg)
+		prevLevel, prevSpread := d.level(prev)
+		chg.Shift=level-prevLevel
+		chg.ShiftPct=safeDiv(100*chg.Shift,math.Abs(prevLevel))
+		switch {
+		case chg.Shift > 0:
+			chg.Direction=1
+		case chg.Shift < 0:
+			chg.Direction=-1
+		}
+
+		//cohen's d, standard deviations pooled by segment length
+		n1=float64(prev.ChgEndIndex-prev.ChgStartIndex+1)
+		n2=float64(chg.ChgEndIndex-chg.ChgStartIndex+1)
+		pooled=math.Sqrt((n1*prevSpread*prevSpread+n2*spread*spread)/(n1+n2))
+		chg.EffectSize=safeDiv(chg.Shift,pooled)
+		chg.VarRatio=safeDiv(spread*spread,prevSpread*prevSpread)
+	}
+}
+
+func safeDiv(num, den float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Ratio of two statistics, undefined when the denominator is zero
+	//	Input:   numerator, denominator
+	//	Output:  ratio, NaN for a zero denominator
+	//-----------------------------------------------------------------------------------
+
+	if (den == 0){
+		return math.NaN()
+	}
+
+	return num/den
+}
@@ -0,0 +1,191 @@
+package cpd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ///////////////////// CONSTANTS
+const TIME_RFC3339 = "rfc3339" // RFC 3339, with or without fractional seconds
+const TIME_UNIX    = "unix"    // seconds since the epoch, fractions allowed
+const TIME_UNIX_MS = "unixms"  // milliseconds since the epoch
+
+
+// ///////////////////// ERRORS
+var ErrBadTime = errors.New("cpd: time does not match layout")
+
+
+func parseTime(text, layout string, loc *time.Location)(time.Time, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Parses a time stamp.  Layouts are TIME_RFC3339, TIME_UNIX, TIME_UNIX_MS or
+	//  any Go time layout.  Stamps without a zone are read in loc
+	//	Input:   text, layout, location (nil for UTC)
+	//	Output:  time, error wrapping ErrBadTime
+	//-----------------------------------------------------------------------------------
+
+	var t time.Time
+	var err error
+
+	if (loc == nil){
+		loc=time.UTC
+	}
+	text=strings.TrimSpace(text)
+
+	switch (layout){
+	case TIME_UNIX, TIME_UNIX_MS:
+		t, err = parseEpoch(text,layout == TIME_UNIX_MS)
+		if (err == nil){
+			t=t.In(loc)
+		}
+	case TIME_RFC3339:
+		t, err = time.ParseInLocation(time.RFC3339Nano,text,loc)
+	default:
+		t, err = time.ParseInLocation(layout,text,loc)
+	}
+
+	if (err != nil){
+		return t, fmt.Errorf("%w: %v", ErrBadTime, err)
+	}
+
+	return t, nil
+}
+
+func parseEpoch(text string, millis bool)(time.Time, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Parses seconds or milliseconds since the epoch.  The integer and fraction
+	//  digits are read separately so no precision is lost; exponent forms such as
+	//  1.7e9 go through float64
+	//	Input:   text, true for milliseconds
+	//	Output:  time in UTC, error
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var nanos int64
+
+	unitDigits:=9
+	if (millis){
+		unitDigits=6
+	}
+
+	whole, frac, hasFrac := strings.Cut(text,".")
+	neg:=strings.HasPrefix(whole,"-")
+	n, err := strconv.ParseInt(whole,10,64)
+	if (hasFrac) && ((whole == "") || (whole == "-") || (whole == "+")){
+		n, err = 0, nil
+	}
+	if (hasFrac) && (frac == ""){
+		err=strconv.ErrSyntax
+	}
+	for i, digit := range frac{
+		if (digit < '0') || (digit > '9'){
+			err=strconv.ErrSyntax
+			break
+		}
+		if (i < unitDigits){
+			nanos=nanos*10+int64(digit-'0')
+		}
+	}
+
+	if (err != nil){
+		//exponent forms
+		f, ferr := strconv.ParseFloat(text,64)
+		if (ferr != nil){
+			return time.Time{}, ferr
+		}
+		if (math.IsNaN(f)) || (math.IsInf(f,0)){
+			return time.Time{}, strconv.ErrSyntax
+		}
+		if (millis){
+			f=f/1000
+		}
+		sec, part := math.Modf(f)
+		return time.Unix(int64(sec),int64(math.Round(part*1e9))).UTC(), nil
+	}
+
+	for i := len(frac); i < unitDigits; i++ {
+		nanos=nanos*10
+	}
+	if (neg){
+		nanos=-nanos
+	}
+	if (millis){
+		return time.UnixMilli(n).Add(time.Duration(nanos)).UTC(), nil
+	}
+
+	return time.Unix(n,nanos).UTC(), nil
+}
+
+func (c ChgT) Duration()(time.Duration){
+
+	//-----------------------------------------------------------------------------------
+	//  Time covered by a change, from its first to its last sample
+	//	Input:
+	//	Output:  duration, zero if the time column was not parsed
+	//-----------------------------------------------------------------------------------
+
+	if (c.ChgStartTS.IsZero()) || (c.ChgEndTS.IsZero()){
+		return 0
+	}
+
+	return c.ChgEndTS.Sub(c.ChgStartTS)
+}
+
+func (d *Detector) SetTimeLayout(layout string){
+
+	//-----------------------------------------------------------------------------------
+	//  Parses the time column with a layout: TIME_RFC3339, TIME_UNIX, TIME_UNIX_MS or
+	//  a Go time layout.  An empty layout keeps the time column as plain text
+	//	Input:   layout
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.load.TimeLayout=layout
+}
+
+func (d *Detector) SetTimeZone(loc *time.Location){
+
+	//-----------------------------------------------------------------------------------
+	//  Zone for time stamps that do not carry one, and for unix times
+	//	Input:   location, nil for UTC
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.load.TimeZone=loc
+}
+
+func (d *Detector) SetStamps(stamps []time.Time)(error){
+
+	//-----------------------------------------------------------------------------------
+	//  Attaches time stamps to the data set by SetData, one per data point
+	//	Input:   time stamps, nil to drop them
+	//	Output:  error if the count does not match the data
+	//-----------------------------------------------------------------------------------
+
+	if (stamps != nil) && (len(stamps) != len(d.rawData)){
+		return ErrLabelCount
+	}
+
+	d.stampData=append([]time.Time(nil), stamps...)
+	return nil
+}
+
+func (d *Detector) stampAt(index int64)(time.Time){
+
+	//-----------------------------------------------------------------------------------
+	//  Returns the time stamp of a data point
+	//	Input:   data index
+	//	Output:  time, zero if the data has no time stamps
+	//-----------------------------------------------------------------------------------
+
+	if (len(d.stampData) != len(d.rawData)) || (index < 0) || (index >= int64(len(d.stampData))){
+		return time.Time{}
+	}
+
+	return d.stampData[index]
+}
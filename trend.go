@@ -0,0 +1,253 @@
+package cpd
+
+import (
+	"context"
+	"math"
+)
+
+// ///////////////////// CONSTANTS
+const TARGET_TREND = 3 // changes in the fitted line (segmented linear regression)
+
+// ChgT.Changed flag
+const CHG_SLOPE = 4
+
+const MIN_TREND_LEN = 3 // samples each side of a slope change needs
+
+
+// ///////////////////// TYPES
+
+// lineSums accumulates what a least squares line fit needs.
+type lineSums struct {
+	n, x, y, xx, xy, yy float64
+}
+
+
+func (s *lineSums) add(x, y float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Adds a point
+	//	Input:   point
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	s.n=s.n+1
+	s.x=s.x+x
+	s.y=s.y+y
+	s.xx=s.xx+x*x
+	s.xy=s.xy+x*y
+	s.yy=s.yy+y*y
+}
+
+func (s lineSums) minus(o lineSums)(lineSums){
+
+	//-----------------------------------------------------------------------------------
+	//  Sums of the points in s that are not in o
+	//	Input:   sums of a subset of the points
+	//	Output:  sums of the other points
+	//-----------------------------------------------------------------------------------
+
+	return lineSums{s.n-o.n, s.x-o.x, s.y-o.y, s.xx-o.xx, s.xy-o.xy, s.yy-o.yy}
+}
+
+func (s lineSums) sse()(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Residual sum of squares of the least squares line
+	//	Input:
+	//	Output:  sum of squared residuals
+	//-----------------------------------------------------------------------------------
+
+	if (s.n == 0){
+		return 0
+	}
+
+	syy:=s.yy-s.y*s.y/s.n
+	sxx:=s.xx-s.x*s.x/s.n
+	if (sxx <= 0){
+		return math.Max(0,syy)
+	}
+	sxy:=s.xy-s.x*s.y/s.n
+
+	return math.Max(0,syy-sxy*sxy/sxx)
+}
+
+func fitLine(data []float64, offset int64)(float64, float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Fits a line to data, x being the sample index.  NaN (missing) values are
+	//  skipped.  Centered first, so large values keep their precision
+	//	Input:   data, index of the first sample
+	//	Output:  slope per sample, intercept at index 0
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var n,meanX,meanY float64
+	var sxx,sxy float64
+
+	for i, value := range data{
+		if (!math.IsNaN(value)){
+			n=n+1
+			meanX=meanX+float64(i)
+			meanY=meanY+value
+		}
+	}
+	if (n == 0){
+		return 0, math.NaN()
+	}
+	meanX=meanX/n
+	meanY=meanY/n
+
+	for i, value := range data{
+		if (!math.IsNaN(value)){
+			sxx=sxx+(float64(i)-meanX)*(float64(i)-meanX)
+			sxy=sxy+(float64(i)-meanX)*(value-meanY)
+		}
+	}
+	if (sxx == 0){
+		return 0, meanY
+	}
+	slope:=sxy/sxx
+
+	return slope, meanY-slope*(meanX+float64(offset))
+}
+
+func detrend(data []float64)([]float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Residuals of the line fitted to data.  A line fitted to part of the residuals
+	//  leaves the same residuals as one fitted to that part of the data, so the
+	//  residuals can be shuffled to bootstrap a slope change
+	//	Input:   data
+	//	Output:  residuals, NaN where data is missing
+	//-----------------------------------------------------------------------------------
+
+	slope, intercept := fitLine(data,0)
+
+	resid:=make([]float64,len(data))
+	for i, value := range data{
+		resid[i]=value-(intercept+slope*float64(i))
+	}
+
+	return resid
+}
+
+func trendSplit(data []float64, lo, hi int64)(float64, int64){
+
+	//-----------------------------------------------------------------------------------
+	//  Best split of data into two fitted lines.  Single pass over the data after
+	//  the totals and allocation free, it runs once per bootstrap shuffle
+	//	Input:   data to analyze, first and last allowed index of the last sample
+	//		 before the split
+	//	Output:  drop in residual sum of squares against one line, index of the last
+	//		 sample before the split
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var total,left lineSums
+	var best float64
+	var bestIndex int64
+
+	for i, value := range data{
+		if (!math.IsNaN(value)){
+			total.add(float64(i),value)
+		}
+	}
+	if (total.n < 2*MIN_TREND_LEN){
+		return 0, 0
+	}
+	whole:=total.sse()
+
+	for i, value := range data{
+		if (math.IsNaN(value)){
+			continue
+		}
+		left.add(float64(i),value)
+		if (int64(i) < lo) || (int64(i) > hi) || (left.n < MIN_TREND_LEN) || (total.n-left.n < MIN_TREND_LEN){
+			continue
+		}
+
+		drop:=whole-left.sse()-total.minus(left).sse()
+		if (drop > best){
+			best=drop
+			bestIndex=int64(i)
+		}
+	}
+
+	return best, bestIndex
+}
+
+func (d *Detector) testTrend(ctx context.Context, slice []float64, base_start, base_end, lo, hi int64)(testT, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Looks for the most likely slope change in a segment and bootstraps its
+	//  confidence from the residuals of a single line.  Its strength is the share
+	//  of the residual sum of squares the split explains
+	//	Input:   context, segment data, segment bounds, allowed split window
+	//	Output:  test outcome, error if cancelled
+	//-----------------------------------------------------------------------------------
+
+	var total lineSums
+
+	resid:=detrend(slice)
+	origDelta,chgPt:=trendSplit(resid,lo,hi)
+	if (origDelta == 0){
+		return testT{chgPt: chgPt, changed: CHG_SLOPE}, nil
+	}
+
+	conf, iters, err := d.bootstrapConf(ctx,resid,func(data []float64)(float64){
+		delta, _ := trendSplit(data,lo,hi)
+		return delta
+	},origDelta,base_start,base_end,CHG_SLOPE)
+
+	test:=testT{chgPt, conf, iters, CHG_SLOPE, 0}
+	for i, value := range resid{
+		if (!math.IsNaN(value)){
+			total.add(float64(i),value)
+		}
+	}
+	if sse:=total.sse(); sse > 0{
+		test.strength=origDelta/sse
+	}
+
+	return test, err
+}
+
+func angleScale(data []float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Scale that turns a slope into the angle seen on a square plot of the whole
+	//  series: samples across, data range up
+	//	Input:   data
+	//	Output:  samples per unit of data, 0 for flat data
+	//-----------------------------------------------------------------------------------
+
+	lo:=math.Inf(1)
+	hi:=math.Inf(-1)
+	for _, value := range data{
+		if (math.IsNaN(value)){
+			continue
+		}
+		lo=math.Min(lo,value)
+		hi=math.Max(hi,value)
+	}
+	if (hi <= lo){
+		return 0
+	}
+
+	return float64(len(data))/(hi-lo)
+}
+
+func (d *Detector) setTrend(chg *ChgT){
+
+	//-----------------------------------------------------------------------------------
+	//  Fits the line of a segment: slope, intercept, angle and end points
+	//	Input:   change with sample indexes filled in
+	//	Output:  change updated in place
+	//-----------------------------------------------------------------------------------
+
+	chg.Slope,chg.Intercept=fitLine(d.rawData[chg.ChgStartIndex:chg.ChgEndIndex+1],chg.ChgStartIndex)
+	chg.Angle=math.Atan(chg.Slope*d.angleScale)*180/math.Pi
+
+	chg.TrendStart=CoordT{X: float64(chg.ChgStartIndex), Y: chg.Intercept+chg.Slope*float64(chg.ChgStartIndex)}
+	chg.TrendEnd=CoordT{X: float64(chg.ChgEndIndex), Y: chg.Intercept+chg.Slope*float64(chg.ChgEndIndex)}
+}
@@ -0,0 +1,235 @@
+package cpd
+
+import (
+	"context"
+	"math"
+)
+
+// ///////////////////// CONSTANTS
+const TARGET_MEAN    = 0 // changes in the mean (cusum of deviations)
+const TARGET_VAR     = 1 // changes in the variance (cusum of squared deviations),
+                         // a mean shift inflates them too: use TARGET_MEANVAR
+                         // when both may move
+const TARGET_MEANVAR = 2 // changes in either or both, each is tested
+
+// ChgT.Changed flags
+const CHG_MEAN = 1
+const CHG_VAR  = 2
+
+
+// ///////////////////// TYPES
+
+// testT is the outcome of testing a segment for a change.
+type testT struct {
+	chgPt    int64   // last sample before the change, relative to the segment
+	conf     float64 // bootstrap confidence, percent
+	iters    int64   // shuffles used
+	changed  int     // CHG_MEAN, CHG_VAR or CHG_SLOPE
+	strength float64 // statistic on a common scale, see ChgT.Strength
+}
+
+
+func (d *Detector) SetTarget(target int){
+
+	//-----------------------------------------------------------------------------------
+	//  Selects what the bootstrap CUSUM looks for: TARGET_MEAN, TARGET_VAR or
+	//  TARGET_MEANVAR
+	//	Input:   target
+	//	Output:
+	//-----------------------------------------------------------------------------------
+
+	d.opts.Target=target
+}
+
+func sqDeviations(data []float64, avg float64)([]float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Squared deviations from the average, their cusum moves with the variance the
+	//  way the plain cusum moves with the mean.  NaN (missing) values stay NaN
+	//	Input:   data, average
+	//	Output:  squared deviations
+	//-----------------------------------------------------------------------------------
+
+	sq:=make([]float64,len(data))
+	for i, value := range data{
+		sq[i]=(value-avg)*(value-avg)
+	}
+
+	return sq
+}
+
+func (d *Detector) testSegment(ctx context.Context, slice []float64, base_start, base_end, lo, hi int64)(testT, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Looks for the most likely change in a segment and bootstraps its confidence.
+	//  TARGET_MEANVAR runs the mean and the variance test and keeps the more
+	//  confident split, flagged with every property whose test passed and whose
+	//  two sides differ significantly at that split.  Only splits between lo and
+	//  hi are considered, by the data and the shuffles
+	//	Input:   context, segment data, segment bounds, allowed split window
+	//	Output:  test outcome, error if cancelled
+	//-----------------------------------------------------------------------------------
+
+	//a window reaching the last split spans the whole cusum, which ends at zero
+	if (lo == 0) && (hi >= int64(len(slice))-2){
+		hi=int64(len(slice))-1
+	}
+
+	switch (d.opts.Target){
+	case TARGET_TREND:
+		return d.testTrend(ctx,slice,base_start,base_end,lo,hi)
+	case TARGET_MEAN:
+		return d.testMean(ctx,slice,base_start,base_end,lo,hi)
+	case TARGET_VAR:
+		return d.testVar(ctx,slice,base_start,base_end,lo,hi)
+	}
+
+	meanTest, err := d.testMean(ctx,slice,base_start,base_end,lo,hi)
+	if (err != nil){
+		return meanTest, err
+	}
+	varTest, err := d.testVar(ctx,slice,base_start,base_end,lo,hi)
+	if (err != nil){
+		return varTest, err
+	}
+
+	best, other := meanTest, varTest
+	if (varTest.conf > meanTest.conf) ||
+	   ((varTest.conf == meanTest.conf) && (varTest.strength > meanTest.strength)){
+		best, other = varTest, meanTest
+	}
+	if (other.conf < d.opts.MinConf){
+		return best, nil
+	}
+
+	//the other property counts if it also moved at this split
+	alpha:=1-d.opts.MinConf/100
+	left:=slice[:best.chgPt+1]
+	right:=slice[best.chgPt+1:]
+	if (other.changed == CHG_MEAN) && (welchPValue(left,right) <= alpha){
+		best.changed=best.changed|CHG_MEAN
+	}
+	if (other.changed == CHG_VAR) && (fPValue(left,right) <= alpha){
+		best.changed=best.changed|CHG_VAR
+	}
+
+	return best, nil
+}
+
+func (d *Detector) testMean(ctx context.Context, slice []float64, base_start, base_end, lo, hi int64)(testT, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Mean test: the cusum of the data, or of its ranks in robust mode
+	//	Input:   context, segment data, segment bounds, allowed split window
+	//	Output:  test outcome, error if cancelled
+	//-----------------------------------------------------------------------------------
+
+	data:=slice
+	if (d.opts.Robust){
+		data=calcRanks(slice)
+	}
+	avg:=calcAvg(data)
+	origDelta,chgPt:=calcCusumIn(avg,data,lo,hi)
+	conf, iters, err := d.bootstrapConf(ctx,data,d.cusumStat(avg,lo,hi),origDelta,base_start,base_end,0)
+
+	return testT{chgPt, conf, iters, CHG_MEAN, cusumStrength(origDelta,data,avg)}, err
+}
+
+func (d *Detector) testVar(ctx context.Context, slice []float64, base_start, base_end, lo, hi int64)(testT, error){
+
+	//-----------------------------------------------------------------------------------
+	//  Variance test: the cusum of the squared deviations from the segment average;
+	//  shuffling them is the same as shuffling the data.  Robust mode uses the
+	//  ranks of the absolute deviations from the median
+	//	Input:   context, segment data, segment bounds, allowed split window
+	//	Output:  test outcome, error if cancelled
+	//-----------------------------------------------------------------------------------
+
+	var sq []float64
+
+	if (d.opts.Robust){
+		sq=calcRanks(absDeviations(slice,calcMedian(slice)))
+	}else{
+		sq=sqDeviations(slice,calcAvg(slice))
+	}
+	sqAvg:=calcAvg(sq)
+	origDelta,chgPt:=calcCusumIn(sqAvg,sq,lo,hi)
+	conf, iters, err := d.bootstrapConf(ctx,sq,d.cusumStat(sqAvg,lo,hi),origDelta,base_start,base_end,CHG_VAR)
+
+	return testT{chgPt, conf, iters, CHG_VAR, cusumStrength(origDelta,sq,sqAvg)}, err
+}
+
+func fPValue(a, b []float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Two sided F-test for different variances
+	//	Input:   samples of both segments
+	//	Output:  p-value, 1 if either segment has fewer than two values
+	//-----------------------------------------------------------------------------------
+
+	n1, _, v1 := sampleStats(a)
+	n2, _, v2 := sampleStats(b)
+	if (n1 < 2) || (n2 < 2){
+		return 1
+	}
+	if (v1 == 0) || (v2 == 0){
+		if (v1 == v2){
+			return 1
+		}
+		return 0
+	}
+
+	d1:=n1-1
+	d2:=n2-1
+	upper:=incBeta(d2/2,d1/2,d2/(d2+d1*v1/v2))
+
+	return 2*math.Min(upper,1-upper)
+}
+
+func cusumStrength(delta float64, data []float64, avg float64)(float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Cusum range in units of its spread under no change, stdev*sqrt(n), so tests
+	//  of different segments and data compare
+	//	Input:   cusum range, data tested, its average
+	//	Output:  strength, 0 for flat data
+	//-----------------------------------------------------------------------------------
+
+	//var
+	var count float64
+
+	for _, value := range data{
+		if (!math.IsNaN(value)){
+			count++
+		}
+	}
+	sd:=calcStdev(data,avg)
+	if (count == 0) || (sd == 0) || (math.IsNaN(sd)){
+		return 0
+	}
+
+	return delta/(sd*math.Sqrt(count))
+}
+
+func (d *Detector) cusumStat(avg float64, lo, hi int64)(func([]float64) float64){
+
+	//-----------------------------------------------------------------------------------
+	//  Bootstrap statistic of the cusum tests: the cusum range around avg over the
+	//  allowed split window.  Block resamples are drawn with replacement, so they
+	//  are centered on their own average instead
+	//	Input:   segment average, allowed split window
+	//	Output:  statistic
+	//-----------------------------------------------------------------------------------
+
+	if (d.opts.BootMode != BOOT_SHUFFLE){
+		return func(data []float64)(float64){
+			delta, _ := calcCusumIn(calcAvg(data),data,lo,hi)
+			return delta
+		}
+	}
+
+	return func(data []float64)(float64){
+		delta, _ := calcCusumIn(avg,data,lo,hi)
+		return delta
+	}
+}